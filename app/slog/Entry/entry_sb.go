@@ -0,0 +1,129 @@
+// Code generated by sbgen -type Entry -magic entr; DO NOT EDIT.
+
+package Entry
+
+import (
+	"time"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/String"
+	"github.com/p9c/pkg/coding/simplebuffer/Time"
+)
+
+// EntryMagic identifies the serialized form of the Entry message
+var EntryMagic = []byte{'e', 'n', 't', 'r'}
+
+// Container holds the serialized form of the Entry message
+type Container struct {
+	simplebuffer.Container
+}
+
+// Get serializes the Entry into a container
+func Get(in *Entry) Container {
+	return Container{*simplebuffer.Serializers{
+		Time.New().Put(in.Time),
+		String.New().Put(in.Level),
+		String.New().Put(in.Package),
+		String.New().Put(in.CodeLocation),
+		String.New().Put(in.Text),
+	}.CreateContainer(EntryMagic)}
+}
+
+// LoadContainer takes a message byte slice payload and loads it into a container ready to be decoded, after
+// checking that it is a well formed Entry
+func LoadContainer(b []byte) (out *Container, err error) {
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(b, EntryMagic, 5); err != nil {
+		return
+	}
+	out = &Container{*c}
+	return
+}
+
+// GetTime decodes the Time field, returning an error if it is malformed
+func (c *Container) GetTime() (out time.Time, err error) {
+	var b []byte
+	if b, err = c.Get(0); slog.Check(err) {
+		return
+	}
+	v := Time.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetLevel decodes the Level field, returning an error if it is malformed
+func (c *Container) GetLevel() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(1); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetPackage decodes the Package field, returning an error if it is malformed
+func (c *Container) GetPackage() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(2); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetCodeLocation decodes the CodeLocation field, returning an error if it is malformed
+func (c *Container) GetCodeLocation() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(3); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetText decodes the Text field, returning an error if it is malformed
+func (c *Container) GetText() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(4); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// Struct deserializes all the fields of the container into the Entry they were serialized from, returning the
+// error of the first field that is malformed
+func (c *Container) Struct() (out *Entry, err error) {
+	o := &Entry{}
+	if o.Time, err = c.GetTime(); err != nil {
+		return
+	}
+	if o.Level, err = c.GetLevel(); err != nil {
+		return
+	}
+	if o.Package, err = c.GetPackage(); err != nil {
+		return
+	}
+	if o.CodeLocation, err = c.GetCodeLocation(); err != nil {
+		return
+	}
+	if o.Text, err = c.GetText(); err != nil {
+		return
+	}
+	return o, nil
+}
@@ -0,0 +1,53 @@
+// Code generated by sbgen -type Entry -magic entr; DO NOT EDIT.
+
+package Entry
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEntryRoundTrip(t *testing.T) {
+	in := &Entry{
+		Time:         time.Unix(1600000000, 1),
+		Level:        "Level",
+		Package:      "Package",
+		CodeLocation: "CodeLocation",
+		Text:         "Text",
+	}
+	c := Get(in)
+	if !bytes.Equal(c.GetMagic(), EntryMagic) {
+		t.Fatal("container has the wrong magic")
+	}
+	if c.Count() != 5 {
+		t.Fatal("container has", c.Count(), "fields, expected 5")
+	}
+	loaded, err := LoadContainer(c.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadContainer(c.Data[:len(c.Data)-1]); err == nil {
+		t.Fatal("a truncated container was loaded")
+	}
+	out, err := loaded.Struct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !in.Time.Equal(out.Time) {
+		t.Fatal("Time did not survive the round trip", in.Time, out.Time)
+	}
+	if !reflect.DeepEqual(in.Level, out.Level) {
+		t.Fatal("Level did not survive the round trip", in.Level, out.Level)
+	}
+	if !reflect.DeepEqual(in.Package, out.Package) {
+		t.Fatal("Package did not survive the round trip", in.Package, out.Package)
+	}
+	if !reflect.DeepEqual(in.CodeLocation, out.CodeLocation) {
+		t.Fatal("CodeLocation did not survive the round trip", in.CodeLocation, out.CodeLocation)
+	}
+	if !reflect.DeepEqual(in.Text, out.Text) {
+		t.Fatal("Text did not survive the round trip", in.Text, out.Text)
+	}
+}
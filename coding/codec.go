@@ -0,0 +1,104 @@
+package coding
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Codec is a forward error correction scheme that splits a message into shards, enough of which, in any order,
+// reassemble it. Both ends of a link must use the same codec, the shards do not identify which made them
+type Codec interface {
+	// Name is the name the codec is registered and configured by
+	Name() string
+	// Encode splits data into shards
+	Encode(data []byte) (shards [][]byte, err error)
+	// EncodeInto does the same as Encode, reusing the memory of out, which can be the result of an earlier call
+	EncodeInto(out [][]byte, data []byte) (shards [][]byte, err error)
+	// Decode reassembles the data from shards of one message. Shards with the same ID are only counted once, and if
+	// there are not yet enough, the error is ErrInsufficientShards
+	Decode(shards [][]byte) (data []byte, err error)
+	// ShardID returns the position of the shard in its message, which is the same for a duplicate of the shard
+	ShardID(shard []byte) (id int, err error)
+	// MinShards returns the fewest shards of the message the shard belongs to that could decode it, so a receiver need
+	// not attempt to decode with fewer
+	MinShards(shard []byte) (n int, err error)
+}
+
+var (
+	codecsMx sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+// Register makes a codec available by its name. Codec packages register their default configuration when imported
+func Register(c Codec) {
+	codecsMx.Lock()
+	codecs[c.Name()] = c
+	codecsMx.Unlock()
+}
+
+// GetCodec returns the registered codec with the given name
+func GetCodec(name string) (c Codec, err error) {
+	codecsMx.RLock()
+	c, ok := codecs[name]
+	codecsMx.RUnlock()
+	if !ok {
+		err = fmt.Errorf("%w: no codec named %q", ErrInvalidParameters, name)
+	}
+	return
+}
+
+// Codecs returns the names of the registered codecs in sorted order
+func Codecs() (names []string) {
+	codecsMx.RLock()
+	for name := range codecs {
+		names = append(names, name)
+	}
+	codecsMx.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+// Result is the time a codec took to encode and decode a sample in Select
+type Result struct {
+	Codec   Codec
+	Elapsed time.Duration
+}
+
+// Select encodes and decodes the sample with each of the named codecs, or all registered codecs if none are named, the
+// given number of rounds, and returns the results fastest first. The sample should be typical of the messages the codec
+// will carry, as the codecs are not equally fast at every size. Decoding uses all shards but the first so the
+// reconstruction path is measured
+func Select(sample []byte, rounds int, names ...string) (results []Result, err error) {
+	if len(names) == 0 {
+		names = Codecs()
+	}
+	if rounds < 1 {
+		rounds = 1
+	}
+	for _, name := range names {
+		var c Codec
+		if c, err = GetCodec(name); err != nil {
+			return
+		}
+		var shards [][]byte
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			if shards, err = c.EncodeInto(shards, sample); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			var out []byte
+			if out, err = c.Decode(shards[1:]); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if !bytes.Equal(out, sample) {
+				return nil, fmt.Errorf("%w: %s did not decode the sample it encoded", ErrInvalidShard, name)
+			}
+		}
+		results = append(results, Result{Codec: c, Elapsed: time.Since(start)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Elapsed < results[j].Elapsed })
+	return
+}
@@ -0,0 +1,94 @@
+package coding_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/p9c/pkg/coding"
+	_ "github.com/p9c/pkg/coding/fec"
+	_ "github.com/p9c/pkg/coding/fek"
+)
+
+func TestCodecs(t *testing.T) {
+	names := coding.Codecs()
+	if len(names) != 2 || names[0] != "fec" || names[1] != "fek" {
+		t.Fatal("expected the fec and fek codecs to be registered, have", names)
+	}
+	if _, err := coding.GetCodec("none"); !errors.Is(err, coding.ErrInvalidParameters) {
+		t.Fatal("expected an error for an unregistered codec, got", err)
+	}
+	for _, name := range names {
+		c, err := coding.GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{1, 1000, 40000} {
+			data := make([]byte, size)
+			_, _ = rand.Read(data)
+			shards, err := c.Encode(data)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			// every shard but one, with a duplicate of the first
+			in := append([][]byte{shards[len(shards)-1]}, shards[1:]...)
+			out, err := c.Decode(in)
+			if err != nil {
+				t.Fatal(name, size, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatal(name, size, "decoded data does not match")
+			}
+			if _, err = c.Decode(nil); !errors.Is(err, coding.ErrInsufficientShards) {
+				t.Fatal(name, size, "expected insufficient shards, got", err)
+			}
+			// one shard fewer than the minimum never decodes
+			min, err := c.MinShards(shards[0])
+			if err != nil || min < 1 || min > len(shards) {
+				t.Fatal(name, size, "minimum shards is", min, err)
+			}
+			if _, err = c.Decode(shards[:min-1]); !errors.Is(err, coding.ErrInsufficientShards) {
+				t.Fatal(name, size, "decoded with fewer than the minimum shards", err)
+			}
+			first, _ := c.ShardID(shards[0])
+			last, _ := c.ShardID(shards[len(shards)-1])
+			if first == last {
+				t.Fatal(name, "shards have the same ID")
+			}
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	results, err := coding.Select(make([]byte, 1000), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Elapsed > results[1].Elapsed {
+		t.Fatal("expected both codecs to be timed fastest first, got", results)
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	for _, name := range coding.Codecs() {
+		c, _ := coding.GetCodec(name)
+		for _, size := range []int{100, 1000, 10000} {
+			data := make([]byte, size)
+			b.Run(fmt.Sprint(name, "/", size), func(b *testing.B) {
+				var shards [][]byte
+				var err error
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if shards, err = c.EncodeInto(shards, data); err != nil {
+						b.Fatal(err)
+					}
+					if _, err = c.Decode(shards[1:]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Package coding holds what is common to the codecs in its subpackages
+package coding
+
+import "errors"
+
+// The kinds of error the codecs return. Errors are wrapped with the detail of what went wrong, use errors.Is to find
+// their kind
+var (
+	// ErrInvalidParameters means the codec can not be constructed or run with the requested parameters
+	ErrInvalidParameters = errors.New("invalid codec parameters")
+	// ErrTooManySegments means the data is too large for the number of segments the format can describe
+	ErrTooManySegments = errors.New("too many segments")
+	// ErrInsufficientShards means not enough shards are available to reconstruct the data
+	ErrInsufficientShards = errors.New("insufficient shards")
+	// ErrLengthMismatch means shards disagree with each other or with their header about their length
+	ErrLengthMismatch = errors.New("length mismatch")
+	// ErrInvalidShard means a shard is malformed
+	ErrInvalidShard = errors.New("invalid shard")
+)
@@ -0,0 +1,104 @@
+package fec
+
+import (
+	"fmt"
+
+	"github.com/p9c/pkg/coding"
+)
+
+// Name is the name the codec with DefaultRedundancy is registered by
+const Name = "fec"
+
+// DefaultRedundancy is the redundancy of the registered codec, which like the fek codec sends three times the data
+const DefaultRedundancy = 200
+
+func init() {
+	coding.Register(NewCodec(Name, DefaultRedundancy))
+}
+
+// Codec is the segmented scheme of this package as a coding.Codec, the shards of all segments are returned as one list
+type Codec struct {
+	name       string
+	redundancy int
+}
+
+// NewCodec returns a Codec adding the given percentage of parity shards to each segment. It can be made available to
+// transport configuration with coding.Register
+func NewCodec(name string, redundancy int) *Codec {
+	return &Codec{name: name, redundancy: redundancy}
+}
+
+func (c *Codec) Name() string {
+	return c.name
+}
+
+func (c *Codec) Encode(data []byte) ([][]byte, error) {
+	return c.EncodeInto(nil, data)
+}
+
+// EncodeInto encodes data into the shard buffers of out, which are divided up among the segments
+func (c *Codec) EncodeInto(out [][]byte, data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no data to encode", coding.ErrInvalidParameters)
+	}
+	sl := Pieces(len(data), SegmentSize)
+	counts := make([]int, sl)
+	var total int
+	for i := range counts {
+		counts[i] = shardCount(Pieces(segmentLength(len(data), i), ShardSize), c.redundancy)
+		total += counts[i]
+	}
+	if cap(out) < total {
+		grown := make([][]byte, total)
+		copy(grown, out[:cap(out)])
+		out = grown
+	}
+	out = out[:total]
+	// the segments are windows onto out, so the shard buffers EncodeInto allocates land in it
+	segments := make(ShardedSegments, sl)
+	var offset int
+	for i := range segments {
+		segments[i] = out[offset : offset+counts[i] : offset+counts[i]]
+		offset += counts[i]
+	}
+	if _, err := EncodeInto(segments, data, c.redundancy); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Codec) Decode(shards [][]byte) (data []byte, err error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("%w: no shards", coding.ErrInsufficientShards)
+	}
+	var p *Partials
+	if p, err = NewPacket(shards[0]); err != nil {
+		return
+	}
+	for i := range shards[1:] {
+		if err = p.AddShard(shards[i+1]); err != nil {
+			return
+		}
+	}
+	return p.Decode()
+}
+
+// ShardID returns the position of the shard among all the shards of the message
+func (c *Codec) ShardID(shard []byte) (id int, err error) {
+	var h Header
+	if h, err = ParseHeader(shard); err != nil {
+		return
+	}
+	return h.Segment*MaxShards + h.Shard, nil
+}
+
+// MinShards returns the number of data shards of all the segments of the message, as every segment needs that many of
+// its own shards to be decoded
+func (c *Codec) MinShards(shard []byte) (n int, err error) {
+	var h Header
+	if h, err = ParseHeader(shard); err != nil {
+		return
+	}
+	full := h.Length / SegmentSize
+	return full*Pieces(SegmentSize, ShardSize) + Pieces(h.Length-full*SegmentSize, ShardSize), nil
+}
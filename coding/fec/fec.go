@@ -4,18 +4,23 @@
 // and constructs segmented blocks based on 16x 1kb source shards with redundancy-adjusted extra shards to protect
 // against data loss from signal noise or other corruption.
 //
+// Each shard is prefixed with a 16 byte header carrying a version, the segment and shard numbers and counts and the
+// payload length, which allows up to 4gb payloads. Shards with the original 8 byte header, which counted segments in a
+// single byte, can still be decoded.
+//
 // 1kb chunks are used to ensure that regular disruptions of the signal have a better chance of not knocking out enough
 // pieces to cause tx failure.
 package fec
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/templexxx/reedsolomon"
 
 	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
 )
 
 type Segments [][]byte
@@ -26,74 +31,178 @@ const (
 	ShardSize   = 2 << 9
 )
 
-func getEmptyShards(size, count int) (out Segments) {
-	out = make(Segments, count)
-	for i := range out {
-		out[i] = make([]byte, size)
-	}
-	return
-}
-
 // GetShards returns a bundle of segments to be sent or stored in a 1kb segment size with redundancy shards added to
-// each segment's shards that can reconstruct the original message by derivation via the available parity shards.
+// each segment's shards that can reconstruct the original message by derivation via the available parity shards. An
+// error is returned if the buffer or redundancy would exceed what the shard header and the RS codec can represent.
 func GetShards(
 	buf []byte, redundancy int,
-) (out ShardedSegments) {
-	prefix := make([]byte, 4)
-	binary.LittleEndian.PutUint32(prefix, uint32(len(buf)))
-	// the following was eliminated to avoid a second copy of the buffer for 4 bytes
-	// buf = append(prefix, buf...)
-	segments := SegmentBytes(buf, SegmentSize)
-	sl := len(segments)
-	sharded := make(ShardedSegments, sl)
-	for i := range segments {
-		sharded[i] = SegmentBytes(segments[i], ShardSize)
-	}
-	// the foregoing operations should not have required any memory allocations since they were creating new slices so
-	// this should be the only (necessary) allocation of the data the RS codec will work on in situ. Effectively using
-	// Go's slice syntax to create a copy map.
-	out = make(ShardedSegments, sl)
-	for i := range sharded {
-		// add 4 bytes for the shard identifier prefix (segment/segments/shard/shards) and 4 bytes for the total data
-		// payload length which gives required also from a segment (last segments can differ in length), and 8 bytes
-		// means no alignment cost for the copy
-		out[i] = getEmptyShards(ShardSize, len(sharded[i])*(redundancy+100)/100)
-	}
-	for i := range sharded {
-		for j := range sharded[i] {
-			// copy the data out of the segments into place with the additional
-			// segments prepared for the RS codec
-			copy(out[i][j], sharded[i][j])
-		}
+) (out ShardedSegments, err error) {
+	return EncodeInto(nil, buf, redundancy)
+}
+
+// EncodeInto does the same as GetShards but writes the headers and shards into the buffers of out, which can be the
+// result of an earlier call, growing it only where it is too small. The shards are only valid until out is next reused
+func EncodeInto(out ShardedSegments, buf []byte, redundancy int) (ShardedSegments, error) {
+	var views [MaxShards][]byte
+	return encodeInto(out, views[:], buf, redundancy)
+}
+
+// encodeInto writes the shards of buf into out, using views as scratch space for the payload parts of the shards
+func encodeInto(out ShardedSegments, views Segments, buf []byte, redundancy int) (ShardedSegments, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%w: no data to encode", coding.ErrInvalidParameters)
+	}
+	if redundancy < 0 {
+		return nil, fmt.Errorf("%w: negative redundancy %d", coding.ErrInvalidParameters, redundancy)
 	}
+	if uint64(len(buf)) > MaxLength {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the maximum of %d", coding.ErrTooManySegments, len(buf),
+			uint64(MaxLength))
+	}
+	// only the last segment can be shorter, so the first has the most shards
+	if total := shardCount(Pieces(segmentLength(len(buf), 0), ShardSize), redundancy); total > MaxShards {
+		return nil, fmt.Errorf("%w: redundancy of %d%% needs %d shards per segment, more than the maximum of %d",
+			coding.ErrInvalidParameters, redundancy, total, MaxShards)
+	}
+	sl := Pieces(len(buf), SegmentSize)
+	out = growSegments(out, sl)
 	for i := range out {
-		dataLen := len(sharded[i])
-		parityLen := len(out[i]) - dataLen
-		if rs, err := reedsolomon.New(dataLen, parityLen); !slog.Check(err) {
-			if err = rs.Encode(out[i]); slog.Check(err) {
+		segment := buf[i*SegmentSize : i*SegmentSize+segmentLength(len(buf), i)]
+		data := Pieces(len(segment), ShardSize)
+		total := shardCount(data, redundancy)
+		out[i] = growShards(out[i], total)
+		for j := range out[i] {
+			// required shards can be computed based on the length of the payload. Grouping is handled by using the
+			// nonce of GCM-AES encryption for puncture detection and tamper resistance to associate packets in the
+			// decoder
+			h := Header{
+				Version:  HeaderVersion,
+				Segment:  i,
+				Segments: sl,
+				Shard:    j,
+				Shards:   total,
+				Length:   len(buf),
+			}
+			h.Encode(out[i][j])
+			views[j] = out[i][j][HeaderLen:]
+			if j < data {
+				n := copy(views[j], segment[j*ShardSize:])
+				// the buffer may hold an earlier message, so the padding of the last data shard must be cleared
+				for k := n; k < ShardSize; k++ {
+					views[j][k] = 0
+				}
 			}
 		}
+		if total == data {
+			// with no redundancy there is nothing to compute
+			continue
+		}
+		rs, err := getCodec(data, total-data)
+		if err != nil {
+			return nil, err
+		}
+		if err = rs.Encode(views[:total]); err != nil {
+			return nil, fmt.Errorf("%w: encoding segment %d: %v", coding.ErrInvalidParameters, i, err)
+		}
 	}
-	// put shard metadata in front of the shards
-	for i := range out {
-		for j := range out[i] {
-			p := make([]byte, 8)
-			// the segment number
-			p[0] = byte(i)
-			// number of segments
-			p[1] = byte(len(sharded))
-			// shard number
-			p[2] = byte(j)
-			// number of shards
-			p[3] = byte(len(out[i]))
-			// required shards can be computed based on the length of the payload thus shortening this header to a round
-			// 8 bytes. Grouping is handled by using the nonce of GCM-AES encryption for puncture detection and tamper
-			// resistance to associate packets in the decoder
-			copy(p[4:8], prefix)
-			out[i][j] = append(p, out[i][j]...)
+	return out, nil
+}
+
+// growSegments returns s with n segments, keeping the shard buffers of any segments beyond its length for reuse
+func growSegments(s ShardedSegments, n int) ShardedSegments {
+	if cap(s) >= n {
+		return s[:n]
+	}
+	grown := make(ShardedSegments, n)
+	copy(grown, s[:cap(s)])
+	return grown
+}
+
+// growShards returns s with n shards that each have room for a header and a full shard. Missing shards are allocated
+// together in one block
+func growShards(s Segments, n int) Segments {
+	const size = HeaderLen + ShardSize
+	if cap(s) < n {
+		grown := make(Segments, n)
+		copy(grown, s[:cap(s)])
+		s = grown
+	}
+	s = s[:n]
+	var missing int
+	for i := range s {
+		if cap(s[i]) < size {
+			missing++
+		}
+	}
+	var block []byte
+	if missing > 0 {
+		block = make([]byte, missing*size)
+	}
+	for i := range s {
+		if cap(s[i]) < size {
+			s[i], block = block[:size:size], block[size:]
+		} else {
+			s[i] = s[i][:size]
 		}
 	}
-	// fmt.Println()
+	return s
+}
+
+var (
+	codecsMx sync.RWMutex
+	// codecs caches the RS codecs by their number of data and parity shards, as constructing one computes its matrix
+	codecs = make(map[int]*reedsolomon.RS)
+)
+
+// getCodec returns the RS codec for the given number of data and parity shards
+func getCodec(data, parity int) (rs *reedsolomon.RS, err error) {
+	key := data*MaxShards + parity
+	codecsMx.RLock()
+	rs = codecs[key]
+	codecsMx.RUnlock()
+	if rs != nil {
+		return
+	}
+	if rs, err = reedsolomon.New(data, parity); err != nil {
+		return nil, fmt.Errorf("%w: %d data and %d parity shards: %v", coding.ErrInvalidParameters, data, parity,
+			err)
+	}
+	codecsMx.Lock()
+	codecs[key] = rs
+	codecsMx.Unlock()
+	return
+}
+
+// Buffer holds the shards of an encoded message so their memory can be used again for the next one. Buffers are
+// pooled, get one with GetBuffer and hand it back with Release once its shards are no longer used
+type Buffer struct {
+	Shards ShardedSegments
+	views  Segments
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &Buffer{views: make(Segments, MaxShards)}
+	},
+}
+
+// GetBuffer returns a Buffer from the pool
+func GetBuffer() *Buffer {
+	return bufferPool.Get().(*Buffer)
+}
+
+// Release returns the Buffer to the pool. The shards it returned must not be used after this
+func (b *Buffer) Release() {
+	bufferPool.Put(b)
+}
+
+// Encode encodes buf into the Buffer's shards as GetShards does. The shards are only valid until the next call or
+// Release
+func (b *Buffer) Encode(buf []byte, redundancy int) (out ShardedSegments, err error) {
+	if out, err = encodeInto(b.Shards, b.views, buf, redundancy); err != nil {
+		return
+	}
+	b.Shards = out
 	return
 }
 
@@ -106,6 +215,16 @@ func GetShards(
 // }
 // slog.Debug(st)
 
+// shardCount returns the number of data and parity shards for a segment, any redundancy above zero gets at least one
+// parity shard
+func shardCount(data, redundancy int) (total int) {
+	total = data * (redundancy + 100) / 100
+	if redundancy > 0 && total == data {
+		total++
+	}
+	return
+}
+
 func SegmentBytes(buf []byte, lim int) (out [][]byte) {
 	p := Pieces(len(buf), lim)
 	chunks := make([][]byte, p)
@@ -127,36 +246,177 @@ func Pieces(dLen, size int) (s int) {
 	return dLen / size
 }
 
+const (
+	// HeaderMarker in the first byte of a shard marks a versioned header. It is never the first byte of a valid version
+	// 1 header, which is the segment number, as version 1 can only count up to 255 segments
+	HeaderMarker = 0xff
+	// HeaderVersion is the version of the header GetShards writes
+	HeaderVersion = 2
+	// HeaderLenV1 is the length of the version 1 header: segment, segments, shard and shards in a byte each and the
+	// 32 bit payload length
+	HeaderLenV1 = 8
+	// HeaderLen is the length of the version 2 header: marker, version, shard and shards-1 in a byte each, then the
+	// segment, segments and payload length as 32 bit values
+	HeaderLen = 16
+	// MaxShards is the largest number of data and parity shards a segment can have with the RS codec
+	MaxShards = 256
+	// MaxLength is the largest payload the 32 bit length in the header can describe
+	MaxLength = 1<<32 - 1
+)
+
+// Header is the metadata in front of a shard
+type Header struct {
+	Version           int
+	Segment, Segments int
+	Shard, Shards     int
+	// Length is the length of the whole payload the shard is part of
+	Length int
+	// Size is the length of the header in front of the shard
+	Size int
+}
+
+// Encode writes a version 2 header into the first HeaderLen bytes of out
+func (h *Header) Encode(out []byte) {
+	out[0] = HeaderMarker
+	out[1] = HeaderVersion
+	out[2] = byte(h.Shard)
+	out[3] = byte(h.Shards - 1)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(h.Segment))
+	binary.LittleEndian.PutUint32(out[8:12], uint32(h.Segments))
+	binary.LittleEndian.PutUint32(out[12:16], uint32(h.Length))
+}
+
+// Required returns the number of data shards in the shard's segment, which is the minimum needed to decode it
+func (h *Header) Required() int {
+	return Pieces(segmentLength(h.Length, h.Segment), ShardSize)
+}
+
+// segmentLength returns the number of bytes of a payload of the given length that are in a segment
+func segmentLength(length, segment int) (segLen int) {
+	segLen = length - segment*SegmentSize
+	if segLen > SegmentSize {
+		segLen = SegmentSize
+	}
+	return
+}
+
+// ParseHeader reads the header in front of a shard, in either the version 2 or the original 8 byte format
+func ParseHeader(data []byte) (h Header, err error) {
+	if len(data) >= 1 && data[0] == HeaderMarker {
+		if len(data) < HeaderLen {
+			err = fmt.Errorf("%w: %d bytes is not long enough to be a shard", coding.ErrInvalidShard, len(data))
+			return
+		}
+		if data[1] != HeaderVersion {
+			err = fmt.Errorf("%w: unknown shard header version %d", coding.ErrInvalidShard, data[1])
+			return
+		}
+		h = Header{
+			Version:  int(data[1]),
+			Shard:    int(data[2]),
+			Shards:   int(data[3]) + 1,
+			Segment:  int(binary.LittleEndian.Uint32(data[4:8])),
+			Segments: int(binary.LittleEndian.Uint32(data[8:12])),
+			Length:   int(binary.LittleEndian.Uint32(data[12:16])),
+			Size:     HeaderLen,
+		}
+	} else {
+		if len(data) < HeaderLenV1 {
+			err = fmt.Errorf("%w: %d bytes is not long enough to be a shard", coding.ErrInvalidShard, len(data))
+			return
+		}
+		h = Header{
+			Version:  1,
+			Segment:  int(data[0]),
+			Segments: int(data[1]),
+			Shard:    int(data[2]),
+			Shards:   int(data[3]),
+			Length:   int(binary.LittleEndian.Uint32(data[4:8])),
+			Size:     HeaderLenV1,
+		}
+	}
+	if h.Segment >= h.Segments || h.Shard >= h.Shards || h.Segments != Pieces(h.Length, SegmentSize) ||
+		h.Shards < h.Required() {
+		err = fmt.Errorf("%w: shard header is inconsistent", coding.ErrInvalidShard)
+	}
+	return
+}
+
 // GetShardCodecParams reads the shard's prefix to provide the correct parameters for the RS codec the packet requires
 // based on the prefix on a shard (presumably to create the codec when a new packet/group of shards arrives)
 func GetShardCodecParams(data []byte) (
 	seg, segTot, num, tot, req, size int, err error,
 ) {
-	if len(data) <= 3 {
-		err = errors.New("provided data is not long enough to be a shard")
+	var h Header
+	if h, err = ParseHeader(data); err != nil {
 		return
 	}
-	seg, segTot, num, tot = int(data[0]), int(data[1]), int(data[2]), int(data[3])
-	length := binary.LittleEndian.Uint32(data[4:8])
-	size = int(length)
-	req = Pieces(size, ShardSize)
-	return
+	return h.Segment, h.Segments, h.Shard, h.Shards, h.Required(), h.Length, nil
+}
+
+// presence is a bitmap of the shards of a segment that have been received, one bit for each of up to MaxShards
+type presence [MaxShards / 64]uint64
+
+func (p *presence) has(i int) bool {
+	return p[i/64]&(1<<uint(i%64)) != 0
 }
 
-// PartialSegment is a max 16kb long segment with arbitrary redundancy parameters when all of the data segments are
-// successfully received hasAll indicates the segment may be ready to reassemble
+func (p *presence) set(i int) {
+	p[i/64] |= 1 << uint(i%64)
+}
+
+// PartialSegment is a max 16kb long segment with arbitrary redundancy parameters. The shards received so far are
+// marked in present, and when all of the data shards are present the segment can be reassembled without the RS codec
 type PartialSegment struct {
 	data, parity int
-	segment      Segments
-	hasAll       bool
+	// size is the length of each of the shards, which must all be the same for the RS codec
+	size    int
+	segment Segments
+	present presence
+	// count and dataCount are the number of shards and data shards received
+	count, dataCount int
 }
 
+// GetShardCount returns the number of distinct shards received for the segment
 func (p PartialSegment) GetShardCount() (count int) {
+	return p.count
+}
+
+// hasAll returns true if every data shard of the segment has been received
+func (p *PartialSegment) hasAll() bool {
+	return p.segment != nil && p.dataCount == p.data
+}
+
+// reconstruct computes the missing data shards of the segment from the parity shards
+func (p *PartialSegment) reconstruct() (err error) {
+	if p.hasAll() {
+		return
+	}
+	if p.segment == nil || p.count < p.data {
+		return fmt.Errorf("%w: have %d of %d shards", coding.ErrInsufficientShards, p.count, p.data)
+	}
+	var rs *reedsolomon.RS
+	if rs, err = getCodec(p.data, p.parity); err != nil {
+		return
+	}
+	var has, lost []int
 	for i := range p.segment {
-		if p.segment[i] == nil || len(p.segment[i]) > 0 {
-			count++
+		switch {
+		case p.present.has(i):
+			has = append(has, i)
+		case i < p.data:
+			lost = append(lost, i)
+			p.segment[i] = make([]byte, p.size)
 		}
 	}
+	if err = rs.Reconst(p.segment, has, lost); err != nil {
+		return fmt.Errorf("%w: %v", coding.ErrInsufficientShards, err)
+	}
+	for _, i := range lost {
+		p.present.set(i)
+		p.count++
+		p.dataCount++
+	}
 	return
 }
 
@@ -169,59 +429,59 @@ type Partials struct {
 // NewPacket creates a new structure to store a collection of incoming
 // shards when the first of a new packet arrives
 func NewPacket(firstShard []byte) (o *Partials, err error) {
-	o = &Partials{}
-	var segment, totalSegments, shard, totalShards, requiredShards, length int
-	segment, totalSegments, shard, totalShards, requiredShards, length, err = GetShardCodecParams(firstShard)
-	o.nSegs = totalSegments
-	o.length = length
-	o.segments = make([]PartialSegment, o.nSegs)
-	o.segments[segment] = PartialSegment{
-		data:    requiredShards,
-		parity:  totalShards - requiredShards,
-		segment: make(Segments, totalShards),
-	}
-	if o.segments[segment].segment == nil {
-		o.segments[segment].segment = make(Segments, totalShards)
-	}
-	o.segments[segment].segment[shard] = firstShard[8:]
+	var h Header
+	if h, err = ParseHeader(firstShard); err != nil {
+		return nil, err
+	}
+	o = &Partials{nSegs: h.Segments, length: h.Length, segments: make([]PartialSegment, h.Segments)}
+	// the number of data shards of each segment follows from the payload length, the parity is not known until a
+	// shard of the segment arrives
+	for i := range o.segments {
+		o.segments[i].data = Pieces(segmentLength(h.Length, i), ShardSize)
+	}
+	if err = o.AddShard(firstShard); err != nil {
+		return nil, err
+	}
 	return
 }
 
 // AddShard adds a newly received shard to a Partials, ensuring
 // that it has matching parameters (if the HMAC on the packet's wrapper
-// passes it should be unless someone is playing silly buggers)
+// passes it should be unless someone is playing silly buggers). Shards
+// that were already received are ignored
 func (p *Partials) AddShard(newShard []byte) (err error) {
-	var segment, totalSegments, shard, totalShards, requiredShards, length int
-	if segment, totalSegments, shard, totalShards, requiredShards, length, err = GetShardCodecParams(newShard); slog.Check(err) {
-	}
-	if p.nSegs != totalSegments {
-		return errors.New("shard has incorrect segment count for bundle")
-	}
-	if p.length != length {
-		return errors.New("shard specifies different length from the bundle")
-	}
-	p.segments[segment].data = requiredShards
-	p.segments[segment].parity = totalShards - requiredShards
-	if p.segments[segment].segment == nil {
-		p.segments[segment].segment = make(Segments, totalShards)
-	}
-	p.segments[segment].segment[shard] = newShard[8:]
-	// as the pieces are likely to arrive more or less in order, check when the data shards are done
-	// and mark the segment as ready to decode
-	if !p.segments[segment].hasAll {
-		var count int
-		// if we count all of the data shards are present mark the segment as ready to decode
-		for i := range p.segments[segment].segment {
-			if p.segments[segment].segment[i] != nil || len(p.segments[segment].segment[i]) != 0 {
-				count++
-			} else {
-				// if we encounter empty shards, stop counting
-				break
-			}
-		}
-		if count >= p.segments[segment].data {
-			p.segments[segment].hasAll = true
-		}
+	var h Header
+	if h, err = ParseHeader(newShard); slog.Check(err) {
+		return
+	}
+	if p.nSegs != h.Segments {
+		return fmt.Errorf("%w: shard has incorrect segment count for bundle", coding.ErrLengthMismatch)
+	}
+	if p.length != h.Length {
+		return fmt.Errorf("%w: shard specifies different length from the bundle", coding.ErrLengthMismatch)
+	}
+	s := &p.segments[h.Segment]
+	body := newShard[h.Size:]
+	if s.segment == nil {
+		s.parity = h.Shards - s.data
+		s.size = len(body)
+		s.segment = make(Segments, h.Shards)
+	} else if len(s.segment) != h.Shards {
+		return fmt.Errorf("%w: shard specifies %d shards in a segment of %d", coding.ErrLengthMismatch, h.Shards,
+			len(s.segment))
+	}
+	if len(body) != s.size {
+		return fmt.Errorf("%w: shard of %d bytes in a segment of %d byte shards", coding.ErrLengthMismatch,
+			len(body), s.size)
+	}
+	if s.present.has(h.Shard) {
+		return
+	}
+	s.segment[h.Shard] = body
+	s.present.set(h.Shard)
+	s.count++
+	if h.Shard < s.data {
+		s.dataCount++
 	}
 	return
 }
@@ -229,28 +489,18 @@ func (p *Partials) AddShard(newShard []byte) (err error) {
 // HasAllDataShards returns true if all data shards are present in a Partials
 func (p *Partials) HasAllDataShards() bool {
 	for i := range p.segments {
-		s := p.segments[i]
-		if s.segment == nil || !s.hasAll {
+		if !p.segments[i].hasAll() {
 			return false
 		}
 	}
 	return true
 }
 
-// HasMinimum returns true if there may be enough data to decode
+// HasMinimum returns true if there is enough data to decode
 func (p *Partials) HasMinimum() bool {
-	// first check if all segments have all data shards already
-	if p.HasAllDataShards() {
-		return true
-	}
 	for i := range p.segments {
-		// if the segment hasn't got all of the data shards, count total number of shards, otherwise move to the next
-		if !p.segments[i].hasAll {
-			// if the number of shards in the segment is above the required move to the next segment
-			if p.segments[i].GetShardCount() >= p.segments[i].data {
-				continue
-			}
-			// if we encounter a segment with less than required we can return the packet has not got minimum
+		// a segment none of whose shards have arrived can't be decoded, whatever the number of data shards it needs
+		if p.segments[i].segment == nil || p.segments[i].count < p.segments[i].data {
 			return false
 		}
 	}
@@ -266,11 +516,7 @@ func (p *Partials) GetRatio() (out float64) {
 	for i := range p.segments {
 		max += p.segments[i].data + p.segments[i].parity
 		min += p.segments[i].data
-		for j := range p.segments[i].segment {
-			if p.segments[i].segment[j] != nil || len(p.segments[i].segment[j]) != 0 {
-				count++
-			}
-		}
+		count += p.segments[i].count
 	}
 	excess := float64(count - min)
 	beyond := float64(max - min)
@@ -289,33 +535,32 @@ func (p *Partials) GetRatio() (out float64) {
 	return
 }
 
+// Decode joins the data shards of the segments back into the original payload, reconstructing any missing data
+// shards from the parity shards
 func (p *Partials) Decode() (final []byte, err error) {
-	final = make([]byte, p.length)
-	if p.HasAllDataShards() {
-		var parts [][]byte
-		// if all data shards were received we can just join them together and return the original data
-		for i := range p.segments {
-			for j := range p.segments[i].segment {
-				if j <= p.segments[i].data {
-					parts = append(parts, p.segments[i].segment[j])
-				} else {
-					break
-				}
-			}
-		}
-		var cursor int
-		for i := range parts {
-			copy(final[cursor:cursor+len(parts[i])], parts[i])
-			cursor += len(parts[i])
-		}
-		return
-	}
 	if !p.HasMinimum() {
 		return nil, fmt.Errorf(
-			"not enough shards, have %f less than required",
+			"%w: have %f less than required", coding.ErrInsufficientShards,
 			-p.GetRatio(),
 		)
 	}
-	// if we don't have all data shards but have above minimum extra of parity shards we can reconstruct the original
+	final = make([]byte, 0, p.length)
+	for i := range p.segments {
+		s := &p.segments[i]
+		if err = s.reconstruct(); err != nil {
+			return nil, err
+		}
+		start := len(final)
+		for j := 0; j < s.data; j++ {
+			final = append(final, s.segment[j]...)
+		}
+		// the last shard of a segment is padded out to the shard size
+		segLen := segmentLength(p.length, i)
+		if len(final)-start < segLen {
+			return nil, fmt.Errorf("%w: segment %d has %d of %d bytes", coding.ErrLengthMismatch, i,
+				len(final)-start, segLen)
+		}
+		final = final[:start+segLen]
+	}
 	return
 }
@@ -1,9 +1,14 @@
 package fec_test
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
+	mrand "math/rand"
 	"testing"
+	"testing/quick"
 
+	"github.com/p9c/pkg/coding"
 	"github.com/p9c/pkg/coding/fec"
 )
 
@@ -43,9 +48,231 @@ func TestGetShards(t *testing.T) {
 		// 	)
 		// }
 		// slog.Debug(spew.Sdump())
-		_ = fec.GetShards(b, red)
+		if _, err := fec.GetShards(b, red); err != nil {
+			t.Fatal(err)
+		}
 		// t.Log(size, red, len(shards))
 		// }
 	}
 	// }
 }
+
+func TestGetShardsManySegments(t *testing.T) {
+	// more segments than a single byte can count
+	dataLen := fec.SegmentSize*300 + 5
+	shards, err := fec.GetShards(MakeRandomBytes(dataLen), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 301 {
+		t.Fatal("expected 301 segments, got", len(shards))
+	}
+	last := shards[300]
+	h, err := fec.ParseHeader(last[len(last)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != fec.HeaderVersion || h.Segment != 300 || h.Segments != 301 || h.Shard != len(last)-1 ||
+		h.Shards != len(last) || h.Length != dataLen || h.Required() != 1 {
+		t.Fatalf("header did not survive the round trip: %+v", h)
+	}
+}
+
+func TestGetShardsOverflow(t *testing.T) {
+	// 16 data shards with 1500% redundancy is exactly 256 shards, any more overflows the codec
+	if _, err := fec.GetShards(MakeRandomBytes(fec.SegmentSize), 1500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fec.GetShards(MakeRandomBytes(fec.SegmentSize), 1510); !errors.Is(err, coding.ErrInvalidParameters) {
+		t.Fatal("expected invalid parameters for more than 256 shards per segment, got", err)
+	}
+}
+
+func TestParseHeaderV1(t *testing.T) {
+	v1 := []byte{2, 3, 4, 20, 0x10, 0x90, 0x00, 0x00}
+	h, err := fec.ParseHeader(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || h.Segment != 2 || h.Segments != 3 || h.Shard != 4 || h.Shards != 20 ||
+		h.Length != 0x9010 || h.Size != fec.HeaderLenV1 || h.Required() != 5 {
+		t.Fatalf("version 1 header was not decoded correctly: %+v", h)
+	}
+	// a segment count that wrapped to zero in the one byte field is rejected rather than misread
+	if _, err = fec.ParseHeader([]byte{0, 0, 0, 20, 0, 0, 0x40, 0}); err == nil {
+		t.Fatal("expected an error for an inconsistent version 1 header")
+	}
+}
+
+// TestPartialsDropShards drops random subsets of the shards of random payloads and checks that they decode if and
+// only if every segment kept at least as many shards as it has data shards
+func TestPartialsDropShards(t *testing.T) {
+	property := func(seed int64, size uint16, redundancy uint8, dropRate uint8) bool {
+		rng := mrand.New(mrand.NewSource(seed))
+		data := MakeRandomBytes(int(size)%(fec.SegmentSize*3) + 1)
+		shards, err := fec.GetShards(data, int(redundancy)%200)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		enough := true
+		var kept [][]byte
+		for i := range shards {
+			var n int
+			for j := range shards[i] {
+				if rng.Intn(256) >= int(dropRate) {
+					kept = append(kept, shards[i][j])
+					n++
+				}
+			}
+			h, _ := fec.ParseHeader(shards[i][0])
+			if n < h.Required() {
+				enough = false
+			}
+		}
+		if len(kept) == 0 {
+			return !enough
+		}
+		rng.Shuffle(len(kept), func(i, j int) { kept[i], kept[j] = kept[j], kept[i] })
+		p, err := fec.NewPacket(kept[0])
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		for i := range kept[1:] {
+			if err = p.AddShard(kept[i+1]); err != nil {
+				t.Log(err)
+				return false
+			}
+		}
+		// duplicates are not counted twice
+		if err = p.AddShard(kept[0]); err != nil {
+			t.Log(err)
+			return false
+		}
+		if p.HasMinimum() != enough {
+			t.Log("HasMinimum reported", !enough, "with enough shards", enough)
+			return false
+		}
+		out, err := p.Decode()
+		if !enough {
+			return errors.Is(err, coding.ErrInsufficientShards)
+		}
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return bytes.Equal(out, data)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartialsAllDataShards(t *testing.T) {
+	data := MakeRandomBytes(fec.SegmentSize + 100)
+	shards, err := fec.GetShards(data, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := fec.NewPacket(shards[1][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the data shards of the second segment, one missing in the middle of the first
+	if err = p.AddShard(shards[1][1]); err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < 16; j++ {
+		if j == 7 {
+			continue
+		}
+		if err = p.AddShard(shards[0][j]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.HasAllDataShards() || p.HasMinimum() {
+		t.Fatal("a gap in the data shards was not detected")
+	}
+	if err = p.AddShard(shards[0][16]); err != nil {
+		t.Fatal(err)
+	}
+	if p.HasAllDataShards() || !p.HasMinimum() {
+		t.Fatal("a parity shard should make up for the missing data shard")
+	}
+	out, err := p.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decoded data does not match")
+	}
+	if !p.HasAllDataShards() {
+		t.Fatal("reconstructed data shard was not marked present")
+	}
+}
+
+func TestBufferReuse(t *testing.T) {
+	b := fec.GetBuffer()
+	defer b.Release()
+	if _, err := b.Encode(MakeRandomBytes(fec.SegmentSize*2), 50); err != nil {
+		t.Fatal(err)
+	}
+	// the shorter message must not pick up the tail of the previous one
+	data := MakeRandomBytes(100)
+	shards, err := b.Encode(data, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := fec.GetShards(data, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != len(fresh) {
+		t.Fatal("expected", len(fresh), "segments, got", len(shards))
+	}
+	for i := range shards {
+		if len(shards[i]) != len(fresh[i]) {
+			t.Fatal("expected", len(fresh[i]), "shards, got", len(shards[i]))
+		}
+		for j := range shards[i] {
+			if !bytes.Equal(shards[i][j], fresh[i][j]) {
+				t.Fatal("shard", i, j, "differs from a freshly encoded one")
+			}
+		}
+	}
+}
+
+func BenchmarkGetShards(b *testing.B) {
+	data := MakeRandomBytes(fec.SegmentSize * 4)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := fec.GetShards(data, 50); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeInto(b *testing.B) {
+	data := MakeRandomBytes(fec.SegmentSize * 4)
+	var out fec.ShardedSegments
+	var err error
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if out, err = fec.EncodeInto(out, data, 50); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBufferEncode(b *testing.B) {
+	data := MakeRandomBytes(fec.SegmentSize * 4)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := fec.GetBuffer()
+		if _, err := buf.Encode(data, 50); err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
+}
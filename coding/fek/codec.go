@@ -0,0 +1,51 @@
+package fek
+
+import (
+	"fmt"
+
+	"github.com/p9c/pkg/coding"
+)
+
+// Name is the name the codec is registered by
+const Name = "fek"
+
+// Codec is the 9/3 scheme of this package as a coding.Codec
+var Codec coding.Codec = codec{}
+
+func init() {
+	coding.Register(Codec)
+}
+
+type codec struct{}
+
+func (codec) Name() string {
+	return Name
+}
+
+func (codec) Encode(data []byte) ([][]byte, error) {
+	return Encode(data)
+}
+
+func (codec) EncodeInto(out [][]byte, data []byte) ([][]byte, error) {
+	return EncodeInto(out, data)
+}
+
+func (codec) Decode(shards [][]byte) ([]byte, error) {
+	return Decode(shards)
+}
+
+// ShardID returns the share number in the first byte of the shard
+func (codec) ShardID(shard []byte) (id int, err error) {
+	if len(shard) < 2 {
+		return 0, fmt.Errorf("%w: shard is %d bytes long", coding.ErrInvalidShard, len(shard))
+	}
+	return int(shard[0]), nil
+}
+
+// MinShards returns the number of shares the scheme requires, which is the same for every message
+func (c codec) MinShards(shard []byte) (n int, err error) {
+	if _, err = c.ShardID(shard); err != nil {
+		return
+	}
+	return rsRequired, nil
+}
@@ -4,8 +4,12 @@ package fek
 
 import (
 	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
 
 	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
 
 	"github.com/vivint/infectious"
 )
@@ -22,71 +26,173 @@ var (
 	}()
 )
 
-// padData appends a 2 byte length prefix, and pads to a multiple of rsTotal. Max message size is limited to 1<<32 but
-// in our use will never get near this size through higher level protocols breaking packets into sessions
-func padData(data []byte) (out []byte) {
-	dataLen := len(data)
-	prefixBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(prefixBytes, uint32(dataLen))
-	data = append(prefixBytes, data...)
-	dataLen = len(data)
-	chunkLen := (dataLen) / rsTotal
-	chunkMod := (dataLen) % rsTotal
-	if chunkMod != 0 {
-		chunkLen++
-	}
-	padLen := rsTotal*chunkLen - dataLen
-	out = append(data, make([]byte, padLen)...)
-	return
+// padData prefixes data with its 4 byte length and pads it to a multiple of rsTotal, in the memory of out where it is large
+// enough. Max message size is limited to 1<<32 but in our use will never get near this size through higher level
+// protocols breaking packets into sessions
+func padData(out, data []byte) []byte {
+	size := paddedLen(len(data))
+	if cap(out) < size {
+		out = make([]byte, size)
+	}
+	out = out[:size]
+	binary.LittleEndian.PutUint32(out, uint32(len(data)))
+	n := copy(out[4:], data) + 4
+	for i := n; i < size; i++ {
+		out[i] = 0
+	}
+	return out
+}
+
+// paddedLen returns the length of the length prefixed data padded to a multiple of rsTotal
+func paddedLen(dataLen int) int {
+	return (dataLen + 4 + rsTotal - 1) / rsTotal * rsTotal
 }
 
 // Encode turns a byte slice into a set of shards with first byte containing the shard number. Previously this code
 // included a CRC32 but this is unnecessary since the shards will be sent wrapped in HMAC protected encryption
 func Encode(data []byte) (chunks [][]byte, err error) {
-	// First we must pad the data
-	data = padData(data)
-	shares := make([]infectious.Share, rsTotal)
+	return EncodeInto(nil, data)
+}
+
+// EncodeInto does the same as Encode but writes the shards into the memory of chunks, which can be the result of an
+// earlier call, growing it only where it is too small. The shards are only valid until chunks is next reused
+func EncodeInto(chunks [][]byte, data []byte) ([][]byte, error) {
+	return encodeInto(chunks, nil, data)
+}
+
+// encodeInto writes the shards of data into chunks, padding data in the memory of padded
+func encodeInto(chunks [][]byte, padded, data []byte) (out [][]byte, err error) {
+	if rsFEC == nil {
+		return nil, fmt.Errorf("%w: codec was not created", coding.ErrInvalidParameters)
+	}
+	if uint64(len(data)) > math.MaxUint32-4-uint64(rsTotal) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the maximum of %d", coding.ErrInvalidParameters, len(data),
+			uint64(math.MaxUint32-4-rsTotal))
+	}
+	padded = padData(padded, data)
+	size := 1 + len(padded)/rsRequired
+	out = growChunks(chunks, size)
+	// the encoder reuses its share memory between calls, so the data is copied out behind the chunk number
 	output := func(s infectious.Share) {
-		shares[s.Number] = s.DeepCopy()
+		out[s.Number][0] = byte(s.Number)
+		copy(out[s.Number][1:], s.Data)
 	}
-	err = rsFEC.Encode(data, output)
-	if err != nil {
-		slog.Error(err)
-		return
+	if err = rsFEC.Encode(padded, output); err != nil {
+		return nil, fmt.Errorf("%w: %v", coding.ErrInvalidParameters, err)
 	}
-	for i := range shares {
-		// Append the chunk number to the front of the chunk
-		chunk := append([]byte{byte(shares[i].Number)}, shares[i].Data...)
-		// Checksum includes chunk number byte so we know if its checksum is incorrect so could the chunk number be
+	return
+}
 
-		// checksum := crc32.Checksum(chunk, crc32.MakeTable(crc32.Castagnoli))
-		// checkBytes := make([]byte, 4)
-		// binary.LittleEndian.PutUint32(checkBytes, checksum)
-		// chunk = append(chunk, checkBytes...)
-		chunks = append(chunks, chunk)
+// growChunks returns chunks with rsTotal chunks of size bytes. Chunks that are too small are allocated together in one
+// block
+func growChunks(chunks [][]byte, size int) [][]byte {
+	if cap(chunks) < rsTotal {
+		grown := make([][]byte, rsTotal)
+		copy(grown, chunks[:cap(chunks)])
+		chunks = grown
+	}
+	chunks = chunks[:rsTotal]
+	var missing int
+	for i := range chunks {
+		if cap(chunks[i]) < size {
+			missing++
+		}
 	}
-	// L.Spew(chunks)
+	var block []byte
+	if missing > 0 {
+		block = make([]byte, missing*size)
+	}
+	for i := range chunks {
+		if cap(chunks[i]) < size {
+			chunks[i], block = block[:size:size], block[size:]
+		} else {
+			chunks[i] = chunks[i][:size]
+		}
+	}
+	return chunks
+}
+
+// Buffer holds the shards of an encoded message so their memory can be used again for the next one. Buffers are
+// pooled, get one with GetBuffer and hand it back with Release once its shards are no longer used
+type Buffer struct {
+	Shards [][]byte
+	padded []byte
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(Buffer)
+	},
+}
+
+// GetBuffer returns a Buffer from the pool
+func GetBuffer() *Buffer {
+	return bufferPool.Get().(*Buffer)
+}
+
+// Release returns the Buffer to the pool. The shards it returned must not be used after this
+func (b *Buffer) Release() {
+	bufferPool.Put(b)
+}
+
+// Encode encodes data into the Buffer's shards as Encode does. The shards are only valid until the next call or
+// Release
+func (b *Buffer) Encode(data []byte) (out [][]byte, err error) {
+	if size := paddedLen(len(data)); cap(b.padded) < size {
+		b.padded = make([]byte, size)
+	}
+	if out, err = encodeInto(b.Shards, b.padded, data); err != nil {
+		return
+	}
+	b.Shards = out
 	return
 }
 
+// Decode reassembles the data from at least 3 of the shards produced by Encode. Duplicate shards are ignored, shards
+// that are malformed or of differing lengths are reported as errors rather than passed to the codec
 func Decode(chunks [][]byte) (data []byte, err error) {
+	if rsFEC == nil {
+		return nil, fmt.Errorf("%w: codec was not created", coding.ErrInvalidParameters)
+	}
 	var shares []infectious.Share
+	seen := make([]bool, rsTotal)
 	for i := range chunks {
-		// bodyLen := len(chunks[i])
-		// L.Spew(chunks[i])
-		body := chunks[i] // [:bodyLen]
-		share := infectious.Share{
-			Number: int(body[0]),
-			Data:   body[1:],
+		body := chunks[i]
+		if len(body) < 2 {
+			return nil, fmt.Errorf("%w: shard %d is %d bytes long", coding.ErrInvalidShard, i, len(body))
+		}
+		number := int(body[0])
+		if number >= rsTotal {
+			return nil, fmt.Errorf("%w: shard number %d is out of range", coding.ErrInvalidShard, number)
+		}
+		if len(shares) > 0 && len(body)-1 != len(shares[0].Data) {
+			return nil, fmt.Errorf("%w: shard %d is %d bytes long, expected %d", coding.ErrLengthMismatch, number,
+				len(body)-1, len(shares[0].Data))
 		}
-		shares = append(shares, share)
+		if seen[number] {
+			continue
+		}
+		seen[number] = true
+		shares = append(shares, infectious.Share{
+			Number: number,
+			Data:   body[1:],
+		})
+	}
+	if len(shares) < rsRequired {
+		return nil, fmt.Errorf("%w: have %d of %d", coding.ErrInsufficientShards, len(shares), rsRequired)
+	}
+	if data, err = rsFEC.Decode(nil, shares); err != nil {
+		return nil, fmt.Errorf("%w: %v", coding.ErrInsufficientShards, err)
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: decoded data is shorter than its length prefix", coding.ErrLengthMismatch)
 	}
-	data, err = rsFEC.Decode(nil, shares)
-	if len(data) > 4 {
-		prefix := data[:4]
-		data = data[4:]
-		dataLen := int(binary.LittleEndian.Uint32(prefix))
-		data = data[:dataLen]
+	dataLen := int(binary.LittleEndian.Uint32(data[:4]))
+	data = data[4:]
+	if dataLen > len(data) {
+		return nil, fmt.Errorf("%w: length prefix of %d exceeds the %d bytes decoded", coding.ErrLengthMismatch,
+			dataLen, len(data))
 	}
+	data = data[:dataLen]
 	return
 }
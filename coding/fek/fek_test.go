@@ -1,7 +1,81 @@
 package fek_test
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/p9c/pkg/coding"
+	"github.com/p9c/pkg/coding/fek"
+)
 
 func TestCodec(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	shards, err := fek.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := fek.Decode(shards[6:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decoded", string(out), "expected", string(data))
+	}
+	if _, err = fek.Decode(shards[:2]); !errors.Is(err, coding.ErrInsufficientShards) {
+		t.Fatal("expected insufficient shards, got", err)
+	}
+	if _, err = fek.Decode([][]byte{shards[0], shards[0], shards[1]}); !errors.Is(err, coding.ErrInsufficientShards) {
+		t.Fatal("duplicate shards were counted, got", err)
+	}
+	short := append([][]byte{shards[0][:len(shards[0])-1]}, shards[1:3]...)
+	if _, err = fek.Decode(short); !errors.Is(err, coding.ErrLengthMismatch) {
+		t.Fatal("expected length mismatch, got", err)
+	}
+}
+
+func TestBufferReuse(t *testing.T) {
+	b := fek.GetBuffer()
+	defer b.Release()
+	long := bytes.Repeat([]byte{0xff}, 1000)
+	if _, err := b.Encode(long); err != nil {
+		t.Fatal(err)
+	}
+	// the shorter message must not pick up the tail of the previous one
+	short := []byte("short")
+	shards, err := b.Encode(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := fek.Encode(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range shards {
+		if !bytes.Equal(shards[i], fresh[i]) {
+			t.Fatal("shard", i, "differs from a freshly encoded one")
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	data := make([]byte, 1200)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := fek.Encode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
+func BenchmarkBufferEncode(b *testing.B) {
+	data := make([]byte, 1200)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := fek.GetBuffer()
+		if _, err := buf.Encode(data); err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
 }
@@ -0,0 +1,329 @@
+// Package fountain implements a rateless LT (Luby transform) code for one to many bulk transfers.
+//
+// Unlike the fixed rate codecs in fec and fek, the sender does not choose the redundancy up front. The data is split
+// into K source blocks and the Encoder produces an unbounded stream of symbols, each the XOR of a pseudo-random set of
+// blocks chosen by the symbol's ID. A receiver feeds whichever symbols reach it to a Decoder until it reports the data
+// is complete, so a receiver with little loss stops early and one with heavy loss just listens for longer.
+//
+// The number of blocks in a symbol is drawn from the robust soliton distribution. Whatever its loss rate, a receiver
+// typically needs 10-30% more symbols than there are blocks, the larger K the smaller the overhead. The symbols are not
+// systematic, as sending the blocks themselves first saves a lossless receiver that overhead but makes the coded
+// symbols that follow much less useful to a receiver that lost some of the blocks.
+//
+// Each symbol carries a 10 byte header with its ID, the payload length and the symbol size, so a Decoder needs nothing
+// but the symbols.
+package fountain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/p9c/pkg/coding"
+)
+
+const (
+	// HeaderLen is the length of the header in front of each symbol: the ID and payload length as 32 bit values and
+	// the symbol size as a 16 bit value
+	HeaderLen = 10
+	// MaxBlocks is the largest number of source blocks a payload can be split into
+	MaxBlocks = 1 << 20
+	// DefaultSymbolSize is a symbol size that fits a symbol and its header in a typical datagram
+	DefaultSymbolSize = 1024
+	// robust soliton distribution parameters
+	solitonC     = 0.03
+	solitonDelta = 0.5
+)
+
+// Encoder produces the symbols of a payload
+type Encoder struct {
+	blocks [][]byte
+	length int
+	size   int
+	cdf    []float64
+	next   uint32
+}
+
+// NewEncoder splits data into blocks of symbolSize bytes, the last padded with zeroes, ready to generate symbols
+func NewEncoder(data []byte, symbolSize int) (e *Encoder, err error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no data to encode", coding.ErrInvalidParameters)
+	}
+	if symbolSize < 1 || symbolSize > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: symbol size %d is not between 1 and %d", coding.ErrInvalidParameters, symbolSize,
+			math.MaxUint16)
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the maximum of %d", coding.ErrInvalidParameters, len(data),
+			uint64(math.MaxUint32))
+	}
+	k := (len(data) + symbolSize - 1) / symbolSize
+	if k > MaxBlocks {
+		return nil, fmt.Errorf("%w: %d blocks of %d bytes exceeds the maximum of %d", coding.ErrTooManySegments, k,
+			symbolSize, MaxBlocks)
+	}
+	e = &Encoder{length: len(data), size: symbolSize, cdf: solitonCDF(k), blocks: make([][]byte, k)}
+	for i := range e.blocks {
+		e.blocks[i] = make([]byte, symbolSize)
+		copy(e.blocks[i], data[i*symbolSize:])
+	}
+	return
+}
+
+// K returns the number of source blocks, which is the least number of symbols a receiver could decode from
+func (e *Encoder) K() int {
+	return len(e.blocks)
+}
+
+// Next returns the symbol following the one Next returned before, starting from ID 0. After 1<<32 symbols the IDs wrap
+// around
+func (e *Encoder) Next() (symbol []byte) {
+	symbol = e.Symbol(e.next)
+	e.next++
+	return
+}
+
+// Symbol returns the symbol with the given ID, which is always the same for the same payload and symbol size
+func (e *Encoder) Symbol(id uint32) (symbol []byte) {
+	symbol = make([]byte, HeaderLen+e.size)
+	binary.LittleEndian.PutUint32(symbol[0:4], id)
+	binary.LittleEndian.PutUint32(symbol[4:8], uint32(e.length))
+	binary.LittleEndian.PutUint16(symbol[8:10], uint16(e.size))
+	value := symbol[HeaderLen:]
+	for _, n := range neighbours(id, len(e.blocks), e.cdf) {
+		xor(value, e.blocks[n])
+	}
+	return
+}
+
+// Decoder reassembles a payload from its symbols by peeling: a symbol with one block left in it that is not yet known
+// gives that block, which is then removed from every symbol that includes it, possibly leaving them with one block
+type Decoder struct {
+	length, size int
+	cdf          []float64
+	blocks       [][]byte
+	recovered    int
+	// waiting lists the symbols that still include each block that has not been recovered
+	waiting  map[int][]*symbol
+	received int
+	seen     map[uint32]struct{}
+}
+
+// symbol is a received symbol not yet reduced to one block
+type symbol struct {
+	value      []byte
+	neighbours []int
+}
+
+// NewDecoder returns a Decoder that takes its parameters from the first symbol added
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// ParseHeader reads the ID, payload length and symbol size from the header of a symbol
+func ParseHeader(s []byte) (id uint32, length, symbolSize int, err error) {
+	if len(s) < HeaderLen {
+		err = fmt.Errorf("%w: %d bytes is not long enough to be a symbol", coding.ErrInvalidShard, len(s))
+		return
+	}
+	id = binary.LittleEndian.Uint32(s[0:4])
+	length = int(binary.LittleEndian.Uint32(s[4:8]))
+	symbolSize = int(binary.LittleEndian.Uint16(s[8:10]))
+	switch {
+	case length == 0 || symbolSize == 0:
+		err = fmt.Errorf("%w: symbol header has zero length or size", coding.ErrInvalidShard)
+	case len(s) != HeaderLen+symbolSize:
+		err = fmt.Errorf("%w: symbol is %d bytes, its header says %d", coding.ErrLengthMismatch, len(s)-HeaderLen,
+			symbolSize)
+	case (length+symbolSize-1)/symbolSize > MaxBlocks:
+		err = fmt.Errorf("%w: symbol header describes more than %d blocks", coding.ErrTooManySegments, MaxBlocks)
+	}
+	return
+}
+
+// Add feeds a symbol to the Decoder and returns true once the payload can be read with Data. Repeated symbols and
+// symbols that arrive after the payload is complete are ignored
+func (d *Decoder) Add(s []byte) (done bool, err error) {
+	var id uint32
+	var length, size int
+	if id, length, size, err = ParseHeader(s); err != nil {
+		return d.Done(), err
+	}
+	if d.blocks == nil {
+		k := (length + size - 1) / size
+		d.length, d.size, d.cdf = length, size, solitonCDF(k)
+		d.blocks = make([][]byte, k)
+		d.waiting = make(map[int][]*symbol)
+		d.seen = make(map[uint32]struct{})
+	} else if length != d.length || size != d.size {
+		return d.Done(), fmt.Errorf("%w: symbol is for %d bytes in %d byte symbols, expected %d in %d",
+			coding.ErrLengthMismatch, length, size, d.length, d.size)
+	}
+	if d.Done() {
+		return true, nil
+	}
+	if _, ok := d.seen[id]; ok {
+		return false, nil
+	}
+	d.seen[id] = struct{}{}
+	d.received++
+	sym := &symbol{value: append([]byte{}, s[HeaderLen:]...)}
+	// remove the blocks that are already known
+	for _, n := range neighbours(id, len(d.blocks), d.cdf) {
+		if d.blocks[n] != nil {
+			xor(sym.value, d.blocks[n])
+		} else {
+			sym.neighbours = append(sym.neighbours, n)
+		}
+	}
+	switch len(sym.neighbours) {
+	case 0:
+		// nothing new in it
+	case 1:
+		d.peel(sym.neighbours[0], sym.value)
+	default:
+		for _, n := range sym.neighbours {
+			d.waiting[n] = append(d.waiting[n], sym)
+		}
+	}
+	return d.Done(), nil
+}
+
+// peel stores a block and peels it out of the symbols that include it, recovering any that are left with one block
+func (d *Decoder) peel(block int, value []byte) {
+	type found struct {
+		block int
+		value []byte
+	}
+	queue := []found{{block, value}}
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		if d.blocks[f.block] != nil {
+			continue
+		}
+		// the value belongs to a symbol that may itself be waiting on the block, so the block gets its own copy
+		d.blocks[f.block] = append([]byte{}, f.value...)
+		d.recovered++
+		for _, sym := range d.waiting[f.block] {
+			xor(sym.value, d.blocks[f.block])
+			for i, n := range sym.neighbours {
+				if n == f.block {
+					sym.neighbours = append(sym.neighbours[:i], sym.neighbours[i+1:]...)
+					break
+				}
+			}
+			if len(sym.neighbours) == 1 && d.blocks[sym.neighbours[0]] == nil {
+				queue = append(queue, found{sym.neighbours[0], sym.value})
+			}
+		}
+		delete(d.waiting, f.block)
+	}
+}
+
+// Done returns true when all of the blocks have been recovered
+func (d *Decoder) Done() bool {
+	return d.blocks != nil && d.recovered == len(d.blocks)
+}
+
+// Received returns the number of distinct symbols that have been added
+func (d *Decoder) Received() int {
+	return d.received
+}
+
+// Progress returns the number of blocks recovered and the total
+func (d *Decoder) Progress() (recovered, total int) {
+	return d.recovered, len(d.blocks)
+}
+
+// Data returns the decoded payload, or ErrInsufficientShards if it is not yet complete
+func (d *Decoder) Data() (data []byte, err error) {
+	if !d.Done() {
+		return nil, fmt.Errorf("%w: have %d of %d blocks", coding.ErrInsufficientShards, d.recovered, len(d.blocks))
+	}
+	data = make([]byte, 0, len(d.blocks)*d.size)
+	for i := range d.blocks {
+		data = append(data, d.blocks[i]...)
+	}
+	return data[:d.length], nil
+}
+
+// neighbours returns the blocks a symbol is made of. A degree is drawn from the robust soliton distribution and that
+// many distinct blocks are chosen, using a generator seeded by the ID so the encoder and decoder agree
+func neighbours(id uint32, k int, cdf []float64) (out []int) {
+	rng := splitMix(uint64(id) + uint64(k)<<32)
+	r := float64(rng.next()>>11) / (1 << 53)
+	// the degree is the first whose cumulative probability reaches r
+	degree := sort.SearchFloat64s(cdf, r) + 1
+	if degree > k {
+		degree = k
+	}
+	// Floyd's algorithm picks degree distinct blocks with one draw each
+	chosen := make(map[int]struct{}, degree)
+	for j := k - degree; j < k; j++ {
+		t := int(rng.next() % uint64(j+1))
+		if _, ok := chosen[t]; ok {
+			t = j
+		}
+		chosen[t] = struct{}{}
+		out = append(out, t)
+	}
+	return
+}
+
+// solitonCDF returns the cumulative robust soliton distribution of degrees 1 to k
+func solitonCDF(k int) (cdf []float64) {
+	kf := float64(k)
+	s := solitonC * math.Log(kf/solitonDelta) * math.Sqrt(kf)
+	spike := int(math.Round(kf / s))
+	if s <= 0 || spike < 1 || spike > k {
+		spike = k
+	}
+	p := make([]float64, k)
+	var total float64
+	for d := 1; d <= k; d++ {
+		// ideal soliton
+		if d == 1 {
+			p[d-1] = 1 / kf
+		} else {
+			p[d-1] = 1 / (float64(d) * float64(d-1))
+		}
+		// robust addition
+		switch {
+		case d < spike:
+			p[d-1] += s / (kf * float64(d))
+		case d == spike:
+			p[d-1] += s * math.Log(s/solitonDelta) / kf
+		}
+		if p[d-1] < 0 {
+			p[d-1] = 0
+		}
+		total += p[d-1]
+	}
+	cdf = make([]float64, k)
+	var sum float64
+	for i := range p {
+		sum += p[i] / total
+		cdf[i] = sum
+	}
+	cdf[k-1] = 1
+	return
+}
+
+// splitMix is the SplitMix64 generator, which is small, fast and the same on every platform
+type splitMix uint64
+
+func (s *splitMix) next() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,129 @@
+package fountain_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/p9c/pkg/coding"
+	"github.com/p9c/pkg/coding/fountain"
+)
+
+// receive feeds the encoder's symbol stream to a decoder, losing each symbol with the given probability, and returns
+// the number of symbols the decoder needed
+func receive(t *testing.T, e *fountain.Encoder, data []byte, loss float64, rng *mrand.Rand) (used int) {
+	d := fountain.NewDecoder()
+	for sent := 0; ; sent++ {
+		if sent > e.K()*20 {
+			t.Fatal("decoder did not finish after", sent, "symbols with", loss, "loss")
+		}
+		s := e.Symbol(uint32(sent))
+		if rng.Float64() < loss {
+			continue
+		}
+		used++
+		done, err := d.Add(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			break
+		}
+	}
+	out, err := d.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decoded data does not match")
+	}
+	return
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := mrand.New(mrand.NewSource(1))
+	for _, size := range []int{1, 1000, 100000, 400000} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		e, err := fountain.NewEncoder(data, fountain.DefaultSymbolSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, loss := range []float64{0, 0.1, 0.3, 0.6} {
+			used := receive(t, e, data, loss, rng)
+			t.Logf("%d bytes, %d blocks, %.0f%% loss: decoded from %d symbols", size, e.K(), loss*100, used)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	e, err := fountain.NewEncoder(data, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := fountain.NewDecoder()
+	for !d.Done() {
+		if _, err = d.Add(e.Next()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := d.Data()
+	if err != nil || !bytes.Equal(out, data) {
+		t.Fatal("decoded data does not match", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := fountain.NewEncoder(nil, 16); !errors.Is(err, coding.ErrInvalidParameters) {
+		t.Fatal("expected invalid parameters for no data, got", err)
+	}
+	e, err := fountain.NewEncoder(make([]byte, 100), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := fountain.NewDecoder()
+	if _, err = d.Data(); !errors.Is(err, coding.ErrInsufficientShards) {
+		t.Fatal("expected insufficient shards before any symbols, got", err)
+	}
+	if _, err = d.Add(e.Symbol(0)[:12]); !errors.Is(err, coding.ErrLengthMismatch) {
+		t.Fatal("expected a length mismatch for a truncated symbol, got", err)
+	}
+	if _, err = d.Add(e.Symbol(1)); err != nil {
+		t.Fatal(err)
+	}
+	other, err := fountain.NewEncoder(make([]byte, 200), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Add(other.Symbol(2)); !errors.Is(err, coding.ErrLengthMismatch) {
+		t.Fatal("expected a length mismatch for a symbol of another payload, got", err)
+	}
+	if _, err = d.Data(); !errors.Is(err, coding.ErrInsufficientShards) {
+		t.Fatal("expected insufficient shards with one symbol, got", err)
+	}
+}
+
+func BenchmarkEncodeDecode(b *testing.B) {
+	data := make([]byte, 1<<20)
+	e, err := fountain.NewEncoder(data, fountain.DefaultSymbolSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := fountain.NewDecoder()
+		// every fourth symbol is lost
+		for id := uint32(0); !d.Done(); id++ {
+			if id%4 == 3 {
+				continue
+			}
+			if _, err = d.Add(e.Symbol(id)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -3,32 +3,234 @@ package gcm
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/p9c/pkg/app/slog"
 
 	"golang.org/x/crypto/argon2"
 )
 
-// GetCipher returns a GCM cipher given a password string. Note that this cipher must be renewed every 4gb of encrypted
-// data
+// KeyParams are the argon2id parameters a key is derived from a password with. Every party to a channel must use the
+// same parameters, as they change the key
+type KeyParams struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is the amount of memory used in KiB
+	Memory uint32
+	// Threads is the number of threads used
+	Threads uint8
+	// KeyLen is the length of the key, 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+	KeyLen uint32
+}
+
+var (
+	// DefaultParams are the parameters GetCipher uses, 64MiB of memory over 4 threads
+	DefaultParams = KeyParams{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+	// LowMemoryParams suit small boards, trading memory for more passes over 8MiB on one thread
+	LowMemoryParams = KeyParams{Time: 4, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	// HighParams are for machines that can afford a key that is more expensive to guess, 256MiB over 4 threads
+	HighParams = KeyParams{Time: 3, Memory: 256 * 1024, Threads: 4, KeyLen: 32}
+	// Presets are the named parameters that can be chosen by configuration
+	Presets = map[string]KeyParams{
+		"default": DefaultParams,
+		"low":     LowMemoryParams,
+		"high":    HighParams,
+	}
+)
+
+// Preset returns the named parameters from Presets
+func Preset(name string) (p KeyParams, err error) {
+	var ok bool
+	if p, ok = Presets[name]; !ok {
+		var names []string
+		for n := range Presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		err = fmt.Errorf("unknown key parameters preset %q, must be one of %v", name, names)
+	}
+	return
+}
+
+// Validate returns an error if the parameters can't derive an AES key
+func (p KeyParams) Validate() (err error) {
+	switch {
+	case p.Time < 1:
+		err = fmt.Errorf("key derivation needs at least one pass")
+	case p.Threads < 1:
+		err = fmt.Errorf("key derivation needs at least one thread")
+	case p.Memory < 8*uint32(p.Threads):
+		err = fmt.Errorf("key derivation needs at least 8KiB of memory per thread")
+	case p.KeyLen != 16 && p.KeyLen != 24 && p.KeyLen != 32:
+		err = fmt.Errorf("key length must be 16, 24 or 32 bytes, not %d", p.KeyLen)
+	}
+	return
+}
+
+const (
+	// MaxCachedKeys is the number of derived keys kept, beyond which the cache is emptied
+	MaxCachedKeys = 64
+	// SaltLen is the length of the salts NewSalt and SaltFromID return
+	SaltLen = 16
+	// MinSaltLen is the shortest salt a salted key can be derived with
+	MinSaltLen = 8
+)
+
+var (
+	keyCacheMx sync.Mutex
+	// keyCache is keyed by a hash of the password, salt and parameters so the password itself is not kept
+	keyCache = make(map[[sha256.Size]byte][]byte)
+	// deriving holds the derivations in progress, so callers wanting the same key wait for the one derivation instead
+	// of all running it
+	deriving = make(map[[sha256.Size]byte]*derivation)
+)
+
+// derivation is a key being derived, its key is set before done is closed
+type derivation struct {
+	done chan struct{}
+	key  []byte
+}
+
+// NewSalt returns a random salt, to be generated once for a deployment and distributed with its configuration
+func NewSalt() (salt []byte, err error) {
+	salt = make([]byte, SaltLen)
+	if _, err = rand.Read(salt); slog.Check(err) {
+		salt = nil
+	}
+	return
+}
+
+// SaltFromID returns a salt for a network or cluster identifier, so deployments with the same password but different
+// identifiers derive different keys
+func SaltFromID(id string) (salt []byte) {
+	h := sha256.Sum256([]byte("gcm salt " + id))
+	return h[:SaltLen]
+}
+
+// DeriveKey returns the unsalted key for a password and parameters. The password is used reversed as the salt, as
+// GetCipher always has, so the same password gives the same key in every deployment. Prefer DeriveSaltedKey for new
+// deployments
+func DeriveKey(password string, p KeyParams) (key []byte, err error) {
+	return deriveKey(password, nil, p)
+}
+
+// DeriveSaltedKey returns the key for a password, salt and parameters. Keys are cached, so only the first call for a
+// password, salt and parameters pays for the derivation
+func DeriveSaltedKey(password string, salt []byte, p KeyParams) (key []byte, err error) {
+	if len(salt) < MinSaltLen {
+		return nil, fmt.Errorf("salt of %d bytes is shorter than the minimum of %d", len(salt), MinSaltLen)
+	}
+	return deriveKey(password, salt, p)
+}
+
+// deriveKey derives the key with the salt, or the reversed password if salt is nil
+func deriveKey(password string, salt []byte, p KeyParams) (key []byte, err error) {
+	if err = p.Validate(); err != nil {
+		return
+	}
+	id := cacheID(password, salt, p)
+	keyCacheMx.Lock()
+	if cached, ok := keyCache[id]; ok {
+		keyCacheMx.Unlock()
+		return append([]byte{}, cached...), nil
+	}
+	if d, ok := deriving[id]; ok {
+		keyCacheMx.Unlock()
+		<-d.done
+		return append([]byte{}, d.key...), nil
+	}
+	d := &derivation{done: make(chan struct{})}
+	deriving[id] = d
+	keyCacheMx.Unlock()
+	// the derivation is slow by design, other keys can be looked up and derived meanwhile
+	pw := []byte(password)
+	if salt == nil {
+		pw = reversed(pw)
+		salt = pw
+	}
+	key = argon2.IDKey(pw, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	d.key = append([]byte{}, key...)
+	keyCacheMx.Lock()
+	delete(deriving, id)
+	if len(keyCache) >= MaxCachedKeys {
+		keyCache = make(map[[sha256.Size]byte][]byte)
+	}
+	keyCache[id] = d.key
+	keyCacheMx.Unlock()
+	close(d.done)
+	return
+}
+
+// ClearKeyCache forgets all of the derived keys
+func ClearKeyCache() {
+	keyCacheMx.Lock()
+	keyCache = make(map[[sha256.Size]byte][]byte)
+	keyCacheMx.Unlock()
+}
+
+func cacheID(password string, salt []byte, p KeyParams) (id [sha256.Size]byte) {
+	h := sha256.New()
+	var params [18]byte
+	binary.BigEndian.PutUint32(params[0:4], p.Time)
+	binary.BigEndian.PutUint32(params[4:8], p.Memory)
+	params[8] = p.Threads
+	binary.BigEndian.PutUint32(params[9:13], p.KeyLen)
+	// an unsalted key and one with an empty salt must not collide
+	if salt != nil {
+		params[13] = 1
+	}
+	binary.BigEndian.PutUint32(params[14:18], uint32(len(salt)))
+	h.Write(params[:])
+	h.Write(salt)
+	h.Write([]byte(password))
+	copy(id[:], h.Sum(nil))
+	return
+}
+
+// GetCipher returns a GCM cipher given a password string, derived with DefaultParams. Note that this cipher must be
+// renewed every 4gb of encrypted data, which a Limited cipher enforces
 func GetCipher(password string) (gcm cipher.AEAD, err error) {
-	bytes := []byte(password)
+	return GetCipherWithParams(password, DefaultParams)
+}
+
+// GetCipherWithParams returns a GCM cipher for a password with the key derived with the given parameters
+func GetCipherWithParams(password string, p KeyParams) (gcm cipher.AEAD, err error) {
+	var key []byte
+	if key, err = DeriveKey(password, p); slog.Check(err) {
+		return
+	}
+	return newGCM(key)
+}
+
+// GetSaltedCipher returns a GCM cipher for a password with the key derived with the given salt and parameters
+func GetSaltedCipher(password string, salt []byte, p KeyParams) (gcm cipher.AEAD, err error) {
+	var key []byte
+	if key, err = DeriveSaltedKey(password, salt, p); slog.Check(err) {
+		return
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (gcm cipher.AEAD, err error) {
 	var c cipher.Block
-	if c, err = aes.NewCipher(
-		argon2.IDKey(
-			reverse(bytes), bytes, 1,
-			64*1024, 4, 32,
-		),
-	); slog.Check(err) {
+	if c, err = aes.NewCipher(key); slog.Check(err) {
+		return
 	}
 	if gcm, err = cipher.NewGCM(c); slog.Check(err) {
 	}
 	return
 }
 
-func reverse(b []byte) []byte {
-	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
-		b[i], b[j] = b[j], b[i]
+// reversed returns a reversed copy of b
+func reversed(b []byte) (r []byte) {
+	r = make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
 	}
-	return b
+	return
 }
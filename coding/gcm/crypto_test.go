@@ -0,0 +1,137 @@
+package gcm
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestDeriveKey(t *testing.T) {
+	ClearKeyCache()
+	password := "key params test"
+	key, err := DeriveKey(password, DefaultParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keys derived with the default parameters must not change from those GetCipher always derived
+	legacy := reversed([]byte(password))
+	if !bytes.Equal(key, argon2.IDKey(legacy, legacy, 1, 64*1024, 4, 32)) {
+		t.Fatal("default parameters derive a different key to before")
+	}
+	// a cached key is a copy, modifying it does not affect the next caller
+	key[0] ^= 0xff
+	again, err := DeriveKey(password, DefaultParams)
+	if err != nil || bytes.Equal(key, again) {
+		t.Fatal("cached key was modified through a returned key", err)
+	}
+	low, err := DeriveKey(password, LowMemoryParams)
+	if err != nil || bytes.Equal(low, again) {
+		t.Fatal("different parameters derived the same key", err)
+	}
+	p := LowMemoryParams
+	p.KeyLen = 16
+	if short, err := DeriveKey(password, p); err != nil || len(short) != 16 {
+		t.Fatal("expected a 16 byte key", err)
+	}
+	if _, err = GetCipherWithParams(password, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyParams(t *testing.T) {
+	for name, p := range Presets {
+		if err := p.Validate(); err != nil {
+			t.Fatal(name, err)
+		}
+		if got, err := Preset(name); err != nil || got != p {
+			t.Fatal("preset", name, "not found", err)
+		}
+	}
+	if _, err := Preset("nonexistent"); err == nil {
+		t.Fatal("expected an error for an unknown preset")
+	}
+	for _, p := range []KeyParams{
+		{Time: 0, Memory: 1024, Threads: 1, KeyLen: 32},
+		{Time: 1, Memory: 1024, Threads: 0, KeyLen: 32},
+		{Time: 1, Memory: 8, Threads: 4, KeyLen: 32},
+		{Time: 1, Memory: 1024, Threads: 1, KeyLen: 20},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Fatal("expected an error for", p)
+		}
+		if _, err := GetCipherWithParams("x", p); err == nil {
+			t.Fatal("expected an error deriving a cipher with", p)
+		}
+	}
+}
+
+func TestSaltedKey(t *testing.T) {
+	ClearKeyCache()
+	password := "salted key test"
+	unsalted, err := DeriveKey(password, LowMemoryParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := SaltFromID("cluster one")
+	original := append([]byte{}, salt...)
+	one, err := DeriveSaltedKey(password, salt, LowMemoryParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(salt, original) {
+		t.Fatal("salt was modified")
+	}
+	if !bytes.Equal(one, argon2.IDKey([]byte(password), salt, 4, 8*1024, 1, 32)) {
+		t.Fatal("salted key is not derived from the password and salt")
+	}
+	two, err := DeriveSaltedKey(password, SaltFromID("cluster two"), LowMemoryParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(one, two) || bytes.Equal(one, unsalted) {
+		t.Fatal("different salts derived the same key")
+	}
+	random, err := NewSalt()
+	if err != nil || len(random) != SaltLen {
+		t.Fatal("expected a random salt", err)
+	}
+	if _, err = GetSaltedCipher(password, random, LowMemoryParams); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DeriveSaltedKey(password, []byte{}, LowMemoryParams); err == nil {
+		t.Fatal("expected an error for an empty salt")
+	}
+	if _, err = GetSaltedCipher(password, random[:MinSaltLen-1], LowMemoryParams); err == nil {
+		t.Fatal("expected an error for a short salt")
+	}
+}
+
+func TestDeriveConcurrent(t *testing.T) {
+	ClearKeyCache()
+	keys := make(chan []byte, 16)
+	for i := 0; i < cap(keys); i++ {
+		// half of the callers want the same key, which is only derived once while they wait
+		password := "concurrent test"
+		if i%2 == 1 {
+			password += string(rune('a' + i))
+		}
+		go func(password string) {
+			key, err := DeriveKey(password, LowMemoryParams)
+			if err != nil {
+				t.Error(err)
+			}
+			keys <- key
+		}(password)
+	}
+	expected := argon2.IDKey([]byte("tset tnerrucnoc"), []byte("tset tnerrucnoc"), 4, 8*1024, 1, 32)
+	var same int
+	for i := 0; i < cap(keys); i++ {
+		if bytes.Equal(<-keys, expected) {
+			same++
+		}
+	}
+	if same != cap(keys)/2 {
+		t.Fatal("expected", cap(keys)/2, "callers to get the shared key, got", same)
+	}
+}
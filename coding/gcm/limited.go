@@ -0,0 +1,128 @@
+package gcm
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrLimitReached is returned when sealing with a Limited cipher would exceed its limits, after which its key must be
+// replaced
+var ErrLimitReached = errors.New("cipher usage limit reached, it must be rekeyed")
+
+// DefaultRekeyAt is the fraction of a limit at which a Limited cipher emits its rekey event if Limits.RekeyAt is zero
+const DefaultRekeyAt = 0.75
+
+// Limits are the most a key may be used for before it must be replaced. A zero limit is no limit
+type Limits struct {
+	// Seals is the number of messages that may be sealed
+	Seals uint64
+	// Bytes is the number of bytes of plaintext that may be sealed
+	Bytes uint64
+	// RekeyAt is the fraction of either limit at which the rekey event is emitted, DefaultRekeyAt if it is zero
+	RekeyAt float64
+}
+
+// DefaultLimits are the limits for suites with 96 bit nonces, on the condition that every message is sealed under its
+// own random nonce. The chance of two of them colliding, which reveals the authentication key, then stays below 2^-32
+// for up to 2^32 messages. A nonce that is reused deliberately, such as for several shards of one message, breaks this
+// at any count, so the limits say nothing about a cipher used that way. The bytes limit is the 4GiB GetCipher has
+// always warned of
+var DefaultLimits = Limits{Seals: 1 << 32, Bytes: 1 << 32}
+
+// SuiteLimits returns the limits that apply to a suite. XChaCha20-Poly1305's 192 bit nonces can be chosen randomly for
+// any practical number of messages, so it has none
+func SuiteLimits(s Suite) Limits {
+	if s == XChaCha20Poly1305 {
+		return Limits{}
+	}
+	return DefaultLimits
+}
+
+// Usage is how much a key has been used
+type Usage struct {
+	Seals uint64
+	Bytes uint64
+}
+
+// Sealer is implemented by ciphers that can refuse to seal. Seal returns an error from a cipher that implements it
+// instead of the unopenable output its cipher.AEAD Seal method would give
+type Sealer interface {
+	TrySeal(dst, nonce, plaintext, additionalData []byte) (out []byte, err error)
+}
+
+// Seal seals with the cipher, returning the error from a Sealer that refuses
+func Seal(ciph cipher.AEAD, dst, nonce, plaintext, additionalData []byte) (out []byte, err error) {
+	if s, ok := ciph.(Sealer); ok {
+		return s.TrySeal(dst, nonce, plaintext, additionalData)
+	}
+	return ciph.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// Limited is a cipher that counts the messages and bytes it seals and refuses to seal past its limits. Once its usage
+// passes the rekey fraction of either limit it calls its rekey handler, once, so the owner can replace the key before
+// sealing starts to fail. Opening is not counted or limited
+type Limited struct {
+	cipher.AEAD
+	limits  Limits
+	onRekey func(Usage)
+	mx      sync.Mutex
+	usage   Usage
+	rekeyed bool
+}
+
+// NewLimited wraps a cipher with the given limits. onRekey may be nil, otherwise it is called from the goroutine that
+// is sealing and should not block
+func NewLimited(ciph cipher.AEAD, limits Limits, onRekey func(Usage)) *Limited {
+	if limits.RekeyAt <= 0 || limits.RekeyAt > 1 {
+		limits.RekeyAt = DefaultRekeyAt
+	}
+	return &Limited{AEAD: ciph, limits: limits, onRekey: onRekey}
+}
+
+// TrySeal seals the plaintext, or returns ErrLimitReached if doing so would exceed the limits
+func (l *Limited) TrySeal(dst, nonce, plaintext, additionalData []byte) (out []byte, err error) {
+	l.mx.Lock()
+	u := Usage{Seals: l.usage.Seals + 1, Bytes: l.usage.Bytes + uint64(len(plaintext))}
+	if (l.limits.Seals > 0 && u.Seals > l.limits.Seals) || (l.limits.Bytes > 0 && u.Bytes > l.limits.Bytes) {
+		l.mx.Unlock()
+		return nil, fmt.Errorf("%w: sealed %d messages of %d bytes", ErrLimitReached, l.usage.Seals, l.usage.Bytes)
+	}
+	l.usage = u
+	rekey := !l.rekeyed && (past(u.Seals, l.limits.Seals, l.limits.RekeyAt) ||
+		past(u.Bytes, l.limits.Bytes, l.limits.RekeyAt))
+	if rekey {
+		l.rekeyed = true
+	}
+	l.mx.Unlock()
+	if rekey && l.onRekey != nil {
+		l.onRekey(u)
+	}
+	return l.AEAD.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// Seal seals the plaintext, or if that would exceed the limits, appends random bytes of the length of the sealed
+// message to dst instead, which fail to open. Code that holds a cipher.AEAD that may be Limited therefore never panics
+// or seals past the limits, but only learns of the refusal from TrySeal, or the package's Seal function
+func (l *Limited) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	out, err := l.TrySeal(dst, nonce, plaintext, additionalData)
+	if err != nil {
+		out = append(dst, make([]byte, len(plaintext)+l.Overhead())...)
+		// should the system random source fail the zeroes left are no more likely to open
+		_, _ = rand.Read(out[len(dst):])
+	}
+	return out
+}
+
+// Usage returns how much the key has been used
+func (l *Limited) Usage() Usage {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.usage
+}
+
+func past(used, limit uint64, fraction float64) bool {
+	return limit > 0 && float64(used) >= float64(limit)*fraction
+}
@@ -0,0 +1,130 @@
+package gcm
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestLimited(t *testing.T) {
+	ciph, err := GetCipherWithParams("limited test", LowMemoryParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rekeys []Usage
+	l := NewLimited(ciph, Limits{Seals: 8, Bytes: 1000, RekeyAt: 0.5}, func(u Usage) {
+		rekeys = append(rekeys, u)
+	})
+	nonce := make([]byte, l.NonceSize())
+	for i := 0; i < 8; i++ {
+		if _, err = l.TrySeal(nil, nonce, make([]byte, 10), nil); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if len(rekeys) != 1 || rekeys[0].Seals != 4 {
+		t.Fatal("expected one rekey event at half the seals, got", rekeys)
+	}
+	if _, err = Seal(l, nil, nonce, nil, nil); !errors.Is(err, ErrLimitReached) {
+		t.Fatal("expected the seal limit to be enforced", err)
+	}
+	if u := l.Usage(); u.Seals != 8 || u.Bytes != 80 {
+		t.Fatal("refused seal was counted", u)
+	}
+	// Seal refuses by returning a message of the right length that does not open
+	refused := l.Seal([]byte("dst"), nonce, []byte("message"), nil)
+	if len(refused) != 3+len("message")+l.Overhead() || string(refused[:3]) != "dst" {
+		t.Fatal("refused seal has the wrong length or lost dst", refused)
+	}
+	if _, err = ciph.Open(nil, nonce, refused[3:], nil); err == nil {
+		t.Fatal("a message refused past the limit opened")
+	}
+	if u := l.Usage(); u.Seals != 8 {
+		t.Fatal("refused seal was counted", u)
+	}
+	// the bytes limit refuses a seal that would pass it without counting it
+	b := NewLimited(ciph, Limits{Bytes: 100}, nil)
+	if _, err = b.TrySeal(nil, nonce, make([]byte, 60), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.TrySeal(nil, nonce, make([]byte, 60), nil); !errors.Is(err, ErrLimitReached) {
+		t.Fatal("expected the bytes limit to be enforced", err)
+	}
+	if _, err = b.TrySeal(nil, nonce, make([]byte, 40), nil); err != nil {
+		t.Fatal("a seal within the bytes limit was refused", err)
+	}
+	// sealed messages open with the unwrapped cipher
+	sealed, err := Seal(NewLimited(ciph, DefaultLimits, nil), nil, nonce, []byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ciph.Open(nil, nonce, sealed, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitedConcurrent(t *testing.T) {
+	ciph, err := GetCipherWithParams("limited test", LowMemoryParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rekeys, refused int
+	var mx sync.Mutex
+	l := NewLimited(ciph, Limits{Seals: 1000}, func(Usage) {
+		mx.Lock()
+		rekeys++
+		mx.Unlock()
+	})
+	nonce := make([]byte, l.NonceSize())
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := l.TrySeal(nil, nonce, nil, nil); err != nil {
+					mx.Lock()
+					refused++
+					mx.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if l.Usage().Seals != 1000 || refused != 600 || rekeys != 1 {
+		t.Fatal("limit not enforced across goroutines", l.Usage(), refused, rekeys)
+	}
+}
+
+func TestConfigLimits(t *testing.T) {
+	var rekeyed bool
+	ciph, err := GetSuiteCipher("limited test", Config{Params: LowMemoryParams, OnRekey: func(Usage) { rekeyed = true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := ciph.(*Limited); !ok || l.limits.Seals != DefaultLimits.Seals {
+		t.Fatal("expected a Limited cipher with the default limits")
+	}
+	ciph, err = GetSuiteCipher("limited test", Config{Params: LowMemoryParams, Limits: Limits{Seals: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, ciph.NonceSize())
+	if _, err = Seal(ciph, nil, nonce, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Seal(ciph, nil, nonce, nil, nil); !errors.Is(err, ErrLimitReached) {
+		t.Fatal("expected the configured limit to be enforced", err)
+	}
+	if rekeyed {
+		t.Fatal("unexpected rekey event")
+	}
+	if ciph, err = GetSuiteCipher("limited test", Config{Params: LowMemoryParams}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ciph.(*Limited); ok {
+		t.Fatal("cipher without limits should not be wrapped")
+	}
+	if SuiteLimits(XChaCha20Poly1305) != (Limits{}) || SuiteLimits(AESGCM) != DefaultLimits {
+		t.Fatal("unexpected suite limits")
+	}
+}
@@ -0,0 +1,142 @@
+package gcm
+
+import (
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/p9c/pkg/app/slog"
+)
+
+// Suite is an AEAD cipher a derived key is used with. Every party to a channel must use the same suite
+type Suite string
+
+const (
+	// AESGCM is AES-GCM, fastest on hardware with AES instructions. Its 96 bit nonces are only safe to generate
+	// randomly for around 2^32 messages per key
+	AESGCM Suite = "aes-gcm"
+	// ChaCha20Poly1305 is ChaCha20-Poly1305, faster than AES-GCM on hardware without AES instructions, such as many ARM
+	// boards. It also has 96 bit nonces
+	ChaCha20Poly1305 Suite = "chacha20-poly1305"
+	// XChaCha20Poly1305 is ChaCha20-Poly1305 with 192 bit nonces, which are safe to generate randomly for any number of
+	// messages
+	XChaCha20Poly1305 Suite = "xchacha20-poly1305"
+	// DefaultSuite is the suite used when none is configured, which is what GetCipher has always returned
+	DefaultSuite = AESGCM
+)
+
+// Suites are the available suites
+var Suites = []Suite{AESGCM, ChaCha20Poly1305, XChaCha20Poly1305}
+
+// ParseSuite returns the suite with the given name, or DefaultSuite if the name is empty
+func ParseSuite(name string) (s Suite, err error) {
+	if name == "" {
+		return DefaultSuite, nil
+	}
+	for _, s = range Suites {
+		if string(s) == name {
+			return
+		}
+	}
+	return "", fmt.Errorf("unknown cipher suite %q, must be one of %v", name, Suites)
+}
+
+// KeyLen returns the key length the suite needs, any of 16, 24 or 32 for AES-GCM
+func (s Suite) KeyLen() (keyLen uint32, err error) {
+	switch s {
+	case AESGCM:
+		keyLen = 32
+	case ChaCha20Poly1305, XChaCha20Poly1305:
+		keyLen = chacha20poly1305.KeySize
+	default:
+		err = fmt.Errorf("unknown cipher suite %q", s)
+	}
+	return
+}
+
+// New returns the suite's cipher with the given key
+func (s Suite) New(key []byte) (ciph cipher.AEAD, err error) {
+	switch s {
+	case AESGCM:
+		return newGCM(key)
+	case ChaCha20Poly1305:
+		if ciph, err = chacha20poly1305.New(key); slog.Check(err) {
+		}
+	case XChaCha20Poly1305:
+		if ciph, err = chacha20poly1305.NewX(key); slog.Check(err) {
+		}
+	default:
+		err = fmt.Errorf("unknown cipher suite %q", s)
+	}
+	return
+}
+
+// Config selects how a cipher is derived from a password. The zero value gives the same cipher as GetCipher
+type Config struct {
+	// Suite is the cipher, DefaultSuite if it is empty
+	Suite Suite
+	// Params are the key derivation parameters, DefaultParams if they are the zero value. The key length is replaced
+	// by the length the suite needs if it is zero
+	Params KeyParams
+	// Salt is the key derivation salt, such as a random value from NewSalt or a SaltFromID, distributed with the
+	// configuration. If it is nil the key is unsalted, as with GetCipher
+	Salt []byte
+	// Limits, if not the zero value, wrap the cipher in a Limited cipher that refuses to seal past them
+	Limits Limits
+	// OnRekey, if set, wraps the cipher in a Limited cipher that calls it when the key should be replaced. If Limits is
+	// the zero value the SuiteLimits of the suite apply
+	OnRekey func(Usage)
+}
+
+// GetSuiteCipher returns the cipher selected by the configuration with a key derived from the password
+func GetSuiteCipher(password string, cfg Config) (ciph cipher.AEAD, err error) {
+	var key []byte
+	if key, err = DeriveSuiteKey(password, cfg); slog.Check(err) {
+		return
+	}
+	return NewSuiteCipher(key, cfg)
+}
+
+// DeriveSuiteKey returns the key GetSuiteCipher derives from the password, for deriving further keys from with a KDF
+// such as HKDF. It must not be used as the key of any other cipher
+func DeriveSuiteKey(password string, cfg Config) (key []byte, err error) {
+	suite := cfg.Suite
+	if suite == "" {
+		suite = DefaultSuite
+	}
+	p := cfg.Params
+	if p == (KeyParams{}) {
+		p = DefaultParams
+	}
+	if p.KeyLen == 0 {
+		if p.KeyLen, err = suite.KeyLen(); slog.Check(err) {
+			return
+		}
+	}
+	if cfg.Salt == nil {
+		key, err = DeriveKey(password, p)
+	} else {
+		key, err = DeriveSaltedKey(password, cfg.Salt, p)
+	}
+	return
+}
+
+// NewSuiteCipher returns the cipher selected by the configuration with the given key, as derived by DeriveSuiteKey
+func NewSuiteCipher(key []byte, cfg Config) (ciph cipher.AEAD, err error) {
+	suite := cfg.Suite
+	if suite == "" {
+		suite = DefaultSuite
+	}
+	if ciph, err = suite.New(key); err != nil {
+		return
+	}
+	limits := cfg.Limits
+	if limits == (Limits{}) && cfg.OnRekey != nil {
+		limits = SuiteLimits(suite)
+	}
+	if limits != (Limits{}) {
+		ciph = NewLimited(ciph, limits, cfg.OnRekey)
+	}
+	return
+}
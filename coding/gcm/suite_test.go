@@ -0,0 +1,62 @@
+package gcm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSuites(t *testing.T) {
+	salt := SaltFromID("suite test")
+	nonceSizes := map[Suite]int{AESGCM: 12, ChaCha20Poly1305: 12, XChaCha20Poly1305: 24}
+	for _, s := range Suites {
+		if got, err := ParseSuite(string(s)); err != nil || got != s {
+			t.Fatal("suite", s, "not found", err)
+		}
+		cfg := Config{Suite: s, Params: LowMemoryParams, Salt: salt}
+		ciph, err := GetSuiteCipher("suite test", cfg)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if ciph.NonceSize() != nonceSizes[s] {
+			t.Fatal(s, "has a nonce size of", ciph.NonceSize())
+		}
+		// the cipher can also be made in two steps from the derived key
+		key, err := DeriveSuiteKey("suite test", cfg)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		again, err := NewSuiteCipher(key, cfg)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		nonce := make([]byte, ciph.NonceSize())
+		msg := []byte("message")
+		opened, err := again.Open(nil, nonce, ciph.Seal(nil, nonce, msg, nil), nil)
+		if err != nil || !bytes.Equal(opened, msg) {
+			t.Fatal(s, "did not open its own message", err)
+		}
+		cfg.Params.KeyLen = 16
+		if _, err = GetSuiteCipher("suite test", cfg); (err == nil) != (s == AESGCM) {
+			t.Fatal(s, "accepted or rejected a 16 byte key wrongly", err)
+		}
+	}
+	if s, err := ParseSuite(""); err != nil || s != DefaultSuite {
+		t.Fatal("empty suite name is not the default", s, err)
+	}
+	if _, err := ParseSuite("rot13"); err == nil {
+		t.Fatal("expected an error for an unknown suite")
+	}
+	// the zero configuration is the same cipher GetCipher returns
+	def, err := GetSuiteCipher("suite test", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := GetCipher("suite test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, legacy.NonceSize())
+	if _, err = def.Open(nil, nonce, legacy.Seal(nil, nonce, nil, nil), nil); err != nil {
+		t.Fatal("default configuration is not the same cipher as GetCipher")
+	}
+}
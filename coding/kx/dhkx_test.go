@@ -8,11 +8,11 @@ import (
 
 type peer struct {
 	priv  *Key
-	group *Group
+	group Exchange
 	pub   *Key
 }
 
-func newPeer(g *Group) *peer {
+func newPeer(g Exchange) *peer {
 	ret := new(peer)
 	ret.priv, _ = g.GenPrivKey()
 	ret.group = g
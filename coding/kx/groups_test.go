@@ -0,0 +1,98 @@
+package kx
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+	sizes := map[int]int{
+		14: 2048, 15: 3072, 16: 4096, 17: 6144, 18: 8192,
+		FFDHE2048: 2048, FFDHE3072: 3072, FFDHE4096: 4096, FFDHE6144: 6144, FFDHE8192: 8192,
+	}
+	for id, bits := range sizes {
+		grp, err := GetGroup(id)
+		if err != nil {
+			t.Fatal(id, err)
+		}
+		if grp.p.BitLen() != bits || grp.Size() != bits/8 {
+			t.Fatal("group", id, "prime is", grp.p.BitLen(), "bits, expected", bits)
+		}
+		if grp.q == nil || new(big.Int).Exp(grp.g, grp.q, grp.p).Cmp(big.NewInt(1)) != 0 {
+			t.Fatal("group", id, "generator is not of order q")
+		}
+		// the larger groups take a long time to check and exchange with
+		if testing.Short() && bits > 3072 {
+			continue
+		}
+		if !grp.q.ProbablyPrime(1) {
+			t.Fatal("group", id, "prime is not a safe prime")
+		}
+		p1, p2 := newPeer(grp), newPeer(grp)
+		if err = exchangeKey(p1, p2); err != nil {
+			t.Fatal(id, err)
+		}
+	}
+	if _, err := GetGroup(255); err == nil {
+		t.Fatal("expected an error for an unknown group")
+	}
+}
+
+func TestGroupValidation(t *testing.T) {
+	grp, err := GetGroup(FFDHE2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := grp.GenPrivKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.x.BitLen() > 225 {
+		t.Fatal("private exponent is", priv.x.BitLen(), "bits")
+	}
+	one := big.NewInt(1)
+	pm1 := new(big.Int).Sub(grp.p, one)
+	// 1 and p-1 force the secret, p-2 is a generator of the whole group rather than the subgroup of order q
+	for _, y := range []*big.Int{big.NewInt(0), one, pm1, new(big.Int).Sub(pm1, one), grp.p} {
+		if _, err = grp.ComputeKey(&Key{y: y}, priv); err == nil {
+			t.Fatal("expected an error for the public key", y)
+		}
+	}
+	if _, err = grp.ComputeKey(priv.Public(), priv); err != nil {
+		t.Fatal("a key in the subgroup was rejected", err)
+	}
+}
+
+func TestSharedKey(t *testing.T) {
+	for _, id := range []int{X25519ID, FFDHE2048} {
+		kx := mustExchange(t, id)
+		a, err := kx.GenPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := kx.GenPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k1, err := SharedKey(kx, b.Public(), a, []byte("test"), 48)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := SharedKey(kx, a.Public(), b, []byte("test"), 48)
+		if err != nil || !bytes.Equal(k1, k2) || len(k1) != 48 {
+			t.Fatal(id, "peers derived different keys", err)
+		}
+		k3, err := SharedKey(kx, a.Public(), b, []byte("other"), 48)
+		if err != nil || bytes.Equal(k1, k3) {
+			t.Fatal(id, "info does not separate the derived keys", err)
+		}
+		raw, _ := kx.ComputeKey(a.Public(), b)
+		if bytes.Contains(raw.Bytes(), k1[:16]) {
+			t.Fatal(id, "derived key is the raw secret")
+		}
+	}
+	if _, err := SharedKey(X25519{}, NewPubKey(make([]byte, 32)), &Key{x: big.NewInt(9)}, nil, 32); err == nil {
+		t.Fatal("expected an error for an invalid public key")
+	}
+}
@@ -0,0 +1,373 @@
+package kx
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// A Handshake is an authenticated key exchange between an initiator and a responder in three messages, in the style of
+// SIGMA-I:
+//
+//	-> version, exchange ID, ephemeral key of the initiator
+//	<- ephemeral key of the responder, sealed(identity and signature of the responder)
+//	-> sealed(identity and signature of the initiator)
+//
+// The ephemeral keys of an Exchange give a shared secret that is only used for this session, so later compromise of
+// the identity keys does not reveal it. The identities are sealed with keys derived from the shared secret, and each
+// side signs with its ed25519 identity key the hash of the transcript of everything sent so far, which includes both
+// ephemeral keys, so a man in the middle can neither substitute its own keys nor replay an old handshake.
+//
+// A pre shared key, if configured, is mixed in to every key derived, so a peer without it can't complete the
+// handshake. With a pre shared key the identities are optional.
+//
+// The session keys, one for each direction, are expanded with HKDF from the shared secret and the final transcript hash
+type Handshake struct {
+	cfg        HandshakeConfig
+	initiator  bool
+	step       int
+	ephemeral  *Key
+	transcript []byte
+	prk        []byte
+	session    *Session
+}
+
+// HandshakeConfig is the configuration of one side of a Handshake
+type HandshakeConfig struct {
+	// Exchange is the ephemeral key exchange, the DefaultID exchange if it is nil. Both sides must use the same one
+	Exchange Exchange
+	// Identity is the key the side signs the transcript with. It may be nil if PSK is set
+	Identity ed25519.PrivateKey
+	// PSK is a pre shared key mixed in to the keys
+	PSK []byte
+	// VerifyPeer decides whether to accept the identity of the peer, which is nil if it did not send one. It must be
+	// set if there is no PSK, as otherwise any peer would be accepted
+	VerifyPeer func(peer ed25519.PublicKey) error
+	// Rand is the source of the ephemeral key, crypto/rand if it is nil
+	Rand io.Reader
+}
+
+// Session is the outcome of a completed Handshake
+type Session struct {
+	// Send is the key for messages to the peer
+	Send []byte
+	// Receive is the key for messages from the peer
+	Receive []byte
+	// Transcript is the hash of the whole handshake, the same for both sides
+	Transcript []byte
+	// Peer is the identity of the peer, nil if it authenticated with the PSK alone
+	Peer ed25519.PublicKey
+}
+
+const (
+	// HandshakeVersion is the version of the handshake protocol, sent in the first message
+	HandshakeVersion = 1
+	// SessionKeySize is the length of the session keys
+	SessionKeySize = 32
+)
+
+// the steps of a handshake
+const (
+	stepStart = iota
+	stepFinish
+	stepDone
+	stepFailed
+)
+
+// ErrHandshake is returned when a handshake fails, whether from a malformed message or a peer that does not
+// authenticate
+var ErrHandshake = errors.New("handshake failed")
+
+var (
+	labelProtocol  = []byte("p9c kx handshake")
+	labelHandshake = []byte("handshake keys")
+	labelSession   = []byte("session keys")
+	labelInitiator = []byte("initiator signature")
+	labelResponder = []byte("responder signature")
+)
+
+// NewInitiator returns the side of a handshake that sends the first message
+func NewInitiator(cfg HandshakeConfig) (h *Handshake, err error) {
+	return newHandshake(cfg, true)
+}
+
+// NewResponder returns the side of a handshake that answers the first message
+func NewResponder(cfg HandshakeConfig) (h *Handshake, err error) {
+	return newHandshake(cfg, false)
+}
+
+func newHandshake(cfg HandshakeConfig, initiator bool) (h *Handshake, err error) {
+	if len(cfg.PSK) == 0 {
+		if cfg.Identity == nil {
+			return nil, errors.New("a handshake needs an identity or a pre shared key to authenticate with")
+		}
+		if cfg.VerifyPeer == nil {
+			return nil, errors.New("a handshake without a pre shared key needs VerifyPeer to authenticate the peer")
+		}
+	}
+	if cfg.Identity != nil && len(cfg.Identity) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid identity key")
+	}
+	if cfg.Exchange == nil {
+		if cfg.Exchange, err = GetExchange(DefaultID); err != nil {
+			return
+		}
+	}
+	if cfg.Rand == nil {
+		cfg.Rand = rand.Reader
+	}
+	h = &Handshake{cfg: cfg, initiator: initiator}
+	var id [3]byte
+	id[0] = HandshakeVersion
+	binary.BigEndian.PutUint16(id[1:], uint16(cfg.Exchange.ID()))
+	t := sha256.Sum256(append(append([]byte{}, labelProtocol...), id[:]...))
+	h.transcript = t[:]
+	return
+}
+
+// Next takes the message received from the peer, nil for the initiator's first call, and returns the message to send
+// to it, nil once the responder has nothing more to send. After the initiator's second call and the responder's
+// second call the handshake is done and Session returns the keys. Any error ends the handshake
+func (h *Handshake) Next(in []byte) (out []byte, err error) {
+	switch {
+	case h.step == stepFailed:
+		return nil, fmt.Errorf("%w: handshake has already failed", ErrHandshake)
+	case h.step == stepDone:
+		return nil, fmt.Errorf("%w: handshake is already done", ErrHandshake)
+	case h.initiator && h.step == stepStart:
+		out, err = h.hello(in)
+	case h.initiator:
+		out, err = h.initiatorFinish(in)
+	case h.step == stepStart:
+		out, err = h.respond(in)
+	default:
+		err = h.responderFinish(in)
+	}
+	if err != nil {
+		h.step = stepFailed
+		if !errors.Is(err, ErrHandshake) {
+			err = fmt.Errorf("%w: %v", ErrHandshake, err)
+		}
+		return nil, err
+	}
+	h.step++
+	return
+}
+
+// Done returns true when the handshake has completed
+func (h *Handshake) Done() bool {
+	return h.step == stepDone
+}
+
+// Session returns the session keys of a completed handshake
+func (h *Handshake) Session() (s *Session, err error) {
+	if !h.Done() {
+		return nil, fmt.Errorf("%w: handshake is not done", ErrHandshake)
+	}
+	return h.session, nil
+}
+
+// hello is the initiator's first message
+func (h *Handshake) hello(in []byte) (out []byte, err error) {
+	if in != nil {
+		return nil, errors.New("the initiator starts the handshake with no message")
+	}
+	if h.ephemeral, err = h.genEphemeral(); err != nil {
+		return
+	}
+	out = make([]byte, 3, 3+h.cfg.Exchange.Size())
+	out[0] = HandshakeVersion
+	binary.BigEndian.PutUint16(out[1:], uint16(h.cfg.Exchange.ID()))
+	out = append(out, h.ephemeral.Bytes()...)
+	h.mix(out)
+	return
+}
+
+// respond answers the initiator's hello with the responder's ephemeral key and sealed identity
+func (h *Handshake) respond(in []byte) (out []byte, err error) {
+	size := h.cfg.Exchange.Size()
+	if len(in) != 3+size {
+		return nil, fmt.Errorf("hello is %d bytes, expected %d", len(in), 3+size)
+	}
+	if in[0] != HandshakeVersion {
+		return nil, fmt.Errorf("unsupported handshake version %d", in[0])
+	}
+	if id := int(binary.BigEndian.Uint16(in[1:])); id != h.cfg.Exchange.ID() {
+		return nil, fmt.Errorf("peer uses exchange %d, expected %d", id, h.cfg.Exchange.ID())
+	}
+	h.mix(in)
+	if h.ephemeral, err = h.genEphemeral(); err != nil {
+		return
+	}
+	e := h.ephemeral.Bytes()
+	h.mix(e)
+	if err = h.extract(in[3:]); err != nil {
+		return
+	}
+	var sealed []byte
+	if sealed, err = h.sealIdentity(labelResponder); err != nil {
+		return
+	}
+	h.mix(sealed)
+	return append(e, sealed...), nil
+}
+
+// initiatorFinish authenticates the responder and returns the initiator's sealed identity
+func (h *Handshake) initiatorFinish(in []byte) (out []byte, err error) {
+	size := h.cfg.Exchange.Size()
+	if len(in) < size {
+		return nil, fmt.Errorf("response is %d bytes, shorter than an ephemeral key", len(in))
+	}
+	h.mix(in[:size])
+	if err = h.extract(in[:size]); err != nil {
+		return
+	}
+	if err = h.openIdentity(in[size:], labelResponder); err != nil {
+		return
+	}
+	h.mix(in[size:])
+	if out, err = h.sealIdentity(labelInitiator); err != nil {
+		return
+	}
+	h.mix(out)
+	h.finish()
+	return
+}
+
+// responderFinish authenticates the initiator
+func (h *Handshake) responderFinish(in []byte) (err error) {
+	if err = h.openIdentity(in, labelInitiator); err != nil {
+		return
+	}
+	h.mix(in)
+	h.finish()
+	return
+}
+
+func (h *Handshake) genEphemeral() (key *Key, err error) {
+	if g, ok := h.cfg.Exchange.(interface {
+		genPrivKey(r io.Reader) (*Key, error)
+	}); ok {
+		return g.genPrivKey(h.cfg.Rand)
+	}
+	return h.cfg.Exchange.GenPrivKey()
+}
+
+// mix hashes a message into the transcript
+func (h *Handshake) mix(b []byte) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	d := sha256.New()
+	d.Write(h.transcript)
+	d.Write(l[:])
+	d.Write(b)
+	h.transcript = d.Sum(nil)
+}
+
+// extract computes the shared secret with the peer's ephemeral key and extracts the key everything else is expanded
+// from, with the PSK as the salt
+func (h *Handshake) extract(peer []byte) (err error) {
+	var secret *Key
+	if secret, err = h.cfg.Exchange.ComputeKey(NewPubKey(peer), h.ephemeral); err != nil {
+		return
+	}
+	h.prk = hkdf.Extract(sha256.New, secret.Bytes(), h.cfg.PSK)
+	return
+}
+
+// expand derives a pair of keys from the current transcript, the first for the initiator and the second for the
+// responder
+func (h *Handshake) expand(label []byte) (initiator, responder []byte) {
+	b := make([]byte, 2*SessionKeySize)
+	_, _ = io.ReadFull(hkdf.Expand(sha256.New, h.prk, append(append([]byte{}, label...), h.transcript...)), b)
+	return b[:SessionKeySize:SessionKeySize], b[SessionKeySize:]
+}
+
+// handshakeCipher returns the cipher the side's identity is sealed with, each key seals once so the nonce is fixed
+func (h *Handshake) handshakeCipher(initiator bool) (ciph cipher.AEAD, err error) {
+	i, r := h.expand(labelHandshake)
+	if initiator {
+		return gcm.ChaCha20Poly1305.New(i)
+	}
+	return gcm.ChaCha20Poly1305.New(r)
+}
+
+// sealIdentity seals the identity and its signature of the transcript, or a zero byte if there is no identity
+func (h *Handshake) sealIdentity(label []byte) (sealed []byte, err error) {
+	payload := []byte{0}
+	if h.cfg.Identity != nil {
+		payload[0] = 1
+		payload = append(payload, h.cfg.Identity.Public().(ed25519.PublicKey)...)
+		payload = append(payload, ed25519.Sign(h.cfg.Identity, append(append([]byte{}, label...),
+			h.transcript...))...)
+	}
+	var ciph cipher.AEAD
+	if ciph, err = h.handshakeCipher(h.initiator); err != nil {
+		return
+	}
+	return ciph.Seal(nil, make([]byte, ciph.NonceSize()), payload, h.transcript), nil
+}
+
+// openIdentity opens the peer's sealed identity, checks its signature and whether it is accepted
+func (h *Handshake) openIdentity(sealed, label []byte) (err error) {
+	var ciph cipher.AEAD
+	if ciph, err = h.handshakeCipher(!h.initiator); err != nil {
+		return
+	}
+	var payload []byte
+	if payload, err = ciph.Open(nil, make([]byte, ciph.NonceSize()), sealed, h.transcript); err != nil {
+		return errors.New("peer's identity did not open, it may not have the same pre shared key")
+	}
+	var peer ed25519.PublicKey
+	switch {
+	case len(payload) == 1 && payload[0] == 0:
+		if len(h.cfg.PSK) == 0 {
+			return errors.New("peer sent no identity and there is no pre shared key to authenticate it")
+		}
+	case len(payload) == 1+ed25519.PublicKeySize+ed25519.SignatureSize && payload[0] == 1:
+		peer = ed25519.PublicKey(payload[1 : 1+ed25519.PublicKeySize])
+		if !ed25519.Verify(peer, append(append([]byte{}, label...), h.transcript...),
+			payload[1+ed25519.PublicKeySize:]) {
+			return errors.New("peer's signature of the transcript is invalid")
+		}
+	default:
+		return errors.New("malformed identity")
+	}
+	if h.cfg.VerifyPeer != nil {
+		if err = h.cfg.VerifyPeer(peer); err != nil {
+			return
+		}
+	}
+	h.session = &Session{Peer: peer}
+	return
+}
+
+// finish derives the session keys from the final transcript
+func (h *Handshake) finish() {
+	i, r := h.expand(labelSession)
+	h.session.Transcript = h.transcript
+	if h.initiator {
+		h.session.Send, h.session.Receive = i, r
+	} else {
+		h.session.Send, h.session.Receive = r, i
+	}
+}
+
+// Ciphers returns ciphers of the suite keyed with the session keys, to seal messages to the peer with send and open
+// messages from it with receive
+func (s *Session) Ciphers(suite gcm.Suite) (send, receive cipher.AEAD, err error) {
+	if send, err = suite.New(s.Send); err != nil {
+		return
+	}
+	receive, err = suite.New(s.Receive)
+	return
+}
@@ -0,0 +1,209 @@
+package kx
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// handshake runs a handshake to completion, passing each message through tamper, which may be nil
+func handshake(t *testing.T, icfg, rcfg HandshakeConfig, tamper func(i int, m []byte) []byte) (si, sr *Session,
+	msgs [][]byte, err error) {
+	var i, r *Handshake
+	if i, err = NewInitiator(icfg); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = NewResponder(rcfg); err != nil {
+		t.Fatal(err)
+	}
+	var m []byte
+	for n, side := range []*Handshake{i, r, i, r} {
+		if m, err = side.Next(m); err != nil {
+			return
+		}
+		if m != nil {
+			if tamper != nil {
+				m = tamper(n, m)
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	if si, err = i.Session(); err != nil {
+		return
+	}
+	sr, err = r.Session()
+	return
+}
+
+// counter is a deterministic source of randomness for test vectors
+type counter byte
+
+func (c *counter) Read(b []byte) (n int, err error) {
+	for i := range b {
+		*c++
+		b[i] = byte(*c)
+	}
+	return len(b), nil
+}
+
+func identity(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func accept(key ed25519.PrivateKey) func(ed25519.PublicKey) error {
+	return func(peer ed25519.PublicKey) error {
+		if !bytes.Equal(peer, key.Public().(ed25519.PublicKey)) {
+			return errors.New("unknown peer")
+		}
+		return nil
+	}
+}
+
+// TestHandshakeVectors pins the messages and keys of a handshake with fixed identities and ephemeral keys, so any
+// change to the protocol, which would stop it interoperating with earlier versions, is caught. The vectors were
+// generated by this implementation, so they only pin its output and do not show that it is correct
+func TestHandshakeVectors(t *testing.T) {
+	alice, bob := identity(1), identity(2)
+	ir, rr := counter(0), counter(100)
+	si, sr, msgs, err := handshake(t,
+		HandshakeConfig{Identity: alice, VerifyPeer: accept(bob), PSK: []byte("psk"), Rand: &ir},
+		HandshakeConfig{Identity: bob, VerifyPeer: accept(alice), PSK: []byte("psk"), Rand: &rr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"01001f07a37cbc142093c8b755dc1b10e86cb426374ad16aa853ed0bdfc0b2b86d1c7c",
+		"5714769d116bf76436ae74bc793d2c30ad1903c59ac5273805c7e2698b410c36ebc6e4c5264d4272188d176472b5feef3919f5b9949e2cb3" +
+			"270afdb92b44d59fc77154fa6c02b3cfd3341196ac4349543e7dbbb62f074f1fed761ec84f18504cde0ad1ef72d66c9941a560ef8471" +
+			"ca01b8f1b6a19639462eeb3190a8a8dcb6fd80fbdb50c995ce45dd3ab9441e3e4998d9",
+		"e1d6f4cdb21f69e89fbebf7efad1ff911196b62a95d39cf6f5cd157cca179eb285e04947a00b52a371e07541062c12db8a7977108f1242e1" +
+			"55960089c2f3e7cacbe749e949f92932f5da68f732a0aa50fc9816770cf9a62bd214ecb2e261a56f61931f2b0ebb9e7b455db7b466cae4" +
+			"13a9",
+	}
+	if len(msgs) != len(expected) {
+		t.Fatal("handshake has", len(msgs), "messages")
+	}
+	for i := range msgs {
+		if hex.EncodeToString(msgs[i]) != expected[i] {
+			t.Fatal("message", i, "differs from the test vector:", hex.EncodeToString(msgs[i]))
+		}
+	}
+	for _, v := range []struct {
+		name string
+		got  []byte
+		hex  string
+	}{
+		{"initiator send key", si.Send, "42d3c5b51ff42aa1ee6df074db851154496d15217cdb54a8a8fd835dc833ce5a"},
+		{"initiator receive key", si.Receive, "0803ca9383103edddbebfa9ce741d2519cceb1696a1fb35d209e27493957a84f"},
+		{"transcript", si.Transcript, "c4aea55627ea6d64b5adbc410062466d043bcd55d60daf20c9b1e2d864701947"},
+	} {
+		if hex.EncodeToString(v.got) != v.hex {
+			t.Fatal(v.name, "differs from the test vector:", hex.EncodeToString(v.got))
+		}
+	}
+	if !bytes.Equal(si.Send, sr.Receive) || !bytes.Equal(si.Receive, sr.Send) ||
+		!bytes.Equal(si.Transcript, sr.Transcript) {
+		t.Fatal("the sides did not derive matching keys")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	alice, bob, mallory := identity(1), identity(2), identity(3)
+	for _, id := range []int{X25519ID, 14} {
+		kx, err := GetExchange(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		si, sr, _, err := handshake(t, HandshakeConfig{Exchange: kx, Identity: alice, VerifyPeer: accept(bob)},
+			HandshakeConfig{Exchange: kx, Identity: bob, VerifyPeer: accept(alice)}, nil)
+		if err != nil {
+			t.Fatal(id, err)
+		}
+		if !bytes.Equal(si.Peer, bob.Public().(ed25519.PublicKey)) ||
+			!bytes.Equal(sr.Peer, alice.Public().(ed25519.PublicKey)) {
+			t.Fatal("sessions have the wrong peers")
+		}
+		send, _, err := si.Ciphers(gcm.XChaCha20Poly1305)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, receive, err := sr.Ciphers(gcm.XChaCha20Poly1305)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, send.NonceSize())
+		if _, err = receive.Open(nil, nonce, send.Seal(nil, nonce, []byte("hello"), nil), nil); err != nil {
+			t.Fatal("session ciphers do not match", err)
+		}
+	}
+	// with a pre shared key neither side needs an identity
+	psk := []byte("pre shared key")
+	si, sr, _, err := handshake(t, HandshakeConfig{PSK: psk}, HandshakeConfig{PSK: psk}, nil)
+	if err != nil || si.Peer != nil || !bytes.Equal(si.Send, sr.Receive) {
+		t.Fatal("pre shared key handshake failed", err)
+	}
+	failures := []struct {
+		name       string
+		icfg, rcfg HandshakeConfig
+		tamper     func(i int, m []byte) []byte
+	}{
+		{"different pre shared keys", HandshakeConfig{PSK: psk}, HandshakeConfig{PSK: []byte("other")}, nil},
+		{"unknown responder", HandshakeConfig{Identity: alice, VerifyPeer: accept(bob)},
+			HandshakeConfig{Identity: mallory, VerifyPeer: accept(alice)}, nil},
+		{"unknown initiator", HandshakeConfig{Identity: mallory, VerifyPeer: accept(bob)},
+			HandshakeConfig{Identity: bob, VerifyPeer: accept(alice)}, nil},
+		{"no identity without a pre shared key", HandshakeConfig{PSK: psk},
+			HandshakeConfig{Identity: bob, VerifyPeer: accept(alice)}, nil},
+		{"different exchanges", HandshakeConfig{Identity: alice, VerifyPeer: accept(bob)},
+			HandshakeConfig{Exchange: mustExchange(t, 14), Identity: bob, VerifyPeer: accept(alice)}, nil},
+		// a man in the middle replacing the responder's ephemeral key can't produce its sealed identity
+		{"substituted ephemeral key", HandshakeConfig{Identity: alice, VerifyPeer: accept(bob)},
+			HandshakeConfig{Identity: bob, VerifyPeer: accept(alice)}, func(i int, m []byte) []byte {
+				if i == 1 {
+					k, _ := X25519{}.GenPrivKey()
+					copy(m, k.Bytes())
+				}
+				return m
+			}},
+		{"tampered identity", HandshakeConfig{Identity: alice, VerifyPeer: accept(bob)},
+			HandshakeConfig{Identity: bob, VerifyPeer: accept(alice)}, func(i int, m []byte) []byte {
+				if i == 2 {
+					m[len(m)-1] ^= 1
+				}
+				return m
+			}},
+	}
+	for _, f := range failures {
+		if _, _, _, err = handshake(t, f.icfg, f.rcfg, f.tamper); !errors.Is(err, ErrHandshake) {
+			t.Fatal(f.name, "did not fail the handshake", err)
+		}
+	}
+	for _, cfg := range []HandshakeConfig{{}, {Identity: alice}, {Identity: ed25519.PrivateKey{1}, PSK: psk}} {
+		if _, err = NewInitiator(cfg); err == nil {
+			t.Fatal("expected an error for an unauthenticated configuration")
+		}
+	}
+	// a failed handshake can't be continued
+	r, _ := NewResponder(HandshakeConfig{PSK: psk})
+	if _, err = r.Next([]byte("garbage")); !errors.Is(err, ErrHandshake) {
+		t.Fatal("expected an error for a malformed hello", err)
+	}
+	if _, err = r.Next(nil); !errors.Is(err, ErrHandshake) {
+		t.Fatal("expected an error continuing a failed handshake", err)
+	}
+	if _, err = r.Session(); err == nil {
+		t.Fatal("expected no session from a failed handshake")
+	}
+}
+
+func mustExchange(t *testing.T, id int) Exchange {
+	kx, err := GetExchange(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kx
+}
@@ -2,21 +2,65 @@ package kx
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
+	"io"
 	"math/big"
 
+	"golang.org/x/crypto/hkdf"
+
 	"github.com/p9c/pkg/app/slog"
 )
 
+// Exchange is a Diffie Hellman key exchange, over a finite field Group or the X25519 curve
+type Exchange interface {
+	// ID is the group number the exchange has in the IKE registry
+	ID() int
+	// Size is the length in bytes of the public keys and shared secrets of the exchange
+	Size() int
+	// GenPrivKey returns a new private key, whose Bytes are the public key to send to the peer
+	GenPrivKey() (key *Key, err error)
+	// ComputeKey returns the secret shared by the owner of a private key and a peer with the public key. It is low level,
+	// the raw secret is not fit to use as a key, so callers outside the package should use SharedKey
+	ComputeKey(pubkey *Key, privkey *Key) (key *Key, err error)
+}
+
+// DefaultID is the ID of the exchange GetExchange returns for an ID of 0
+const DefaultID = X25519ID
+
+// GetExchange returns the exchange with the given ID, X25519ID or one of the finite field groups of GetGroup. An ID of 0
+// selects DefaultID
+func GetExchange(id int) (kx Exchange, err error) {
+	if id <= 0 {
+		id = DefaultID
+	}
+	if id == X25519ID {
+		return X25519{}, nil
+	}
+	var group *Group
+	if group, err = GetGroup(id); err != nil {
+		return
+	}
+	return group, nil
+}
+
+// Key is a private key, public key or shared secret of an Exchange
 type Key struct {
-	x, y  *big.Int
-	group *Group
+	x, y *big.Int
+	kx   Exchange
 }
 
+// GetGroup1 returns the prime of the 768 bit group 1, which is far too small to be secure.
+//
+// Deprecated: use X25519 or group 14
 func GetGroup1() (out *big.Int) {
 	out, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A3620FFFFFFFFFFFFFFFF", 16)
 	return
 }
+
+// GetGroup2 returns the prime of the 1024 bit group 2, which is too small to be secure.
+//
+// Deprecated: use X25519 or group 14
 func GetGroup2() (out *big.Int) {
 	out, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF", 16)
 	return
@@ -39,9 +83,8 @@ func (k *Key) Bytes() (out []byte) {
 	if k.y == nil {
 		return
 	}
-	if k.group != nil {
-		bitLen := (k.group.p.BitLen() + 7) / 8
-		out = make([]byte, bitLen)
+	if k.kx != nil {
+		out = make([]byte, k.kx.Size())
 		copyLeftPad(out, k.y.Bytes())
 	}
 	return
@@ -65,8 +108,24 @@ func NewPubKey(b []byte) (out *Key) {
 	return
 }
 
+// Group is a finite field Diffie Hellman group
 type Group struct {
+	id   int
 	p, g *big.Int
+	// q is the order of the subgroup generated by g, (p-1)/2 for the safe prime groups, nil if it is not known
+	q *big.Int
+	// xBits is the length of the private exponents, zero to choose them from the whole range of the group
+	xBits int
+}
+
+// ID returns the number of the group
+func (grp *Group) ID() int {
+	return grp.id
+}
+
+// Size returns the length in bytes of the group's prime
+func (grp *Group) Size() int {
+	return (grp.p.BitLen() + 7) / 8
 }
 
 func (grp *Group) P() (p *big.Int) {
@@ -82,54 +141,88 @@ func (grp *Group) G() (g *big.Int) {
 }
 
 func (grp *Group) GenPrivKey() (key *Key, err error) {
-	s := rand.Reader
-	var x *big.Int
-	if x, err = rand.Int(s, grp.p); slog.Check(err) {
-		return
+	return grp.genPrivKey(rand.Reader)
+}
+
+// genPrivKey generates a private key from the given source of randomness. For the named groups the exponent is short,
+// twice the bits of the group's security strength, which is as secure and much faster than one as long as the prime
+func (grp *Group) genPrivKey(s io.Reader) (key *Key, err error) {
+	max := grp.p
+	if grp.xBits > 0 {
+		max = new(big.Int).Lsh(big.NewInt(1), uint(grp.xBits))
 	}
-	zero := big.NewInt(0)
-	for x.Cmp(zero) == 0 {
-		if x, err = rand.Int(s, grp.p); slog.Check(err) {
+	var x *big.Int
+	// exponents of 0 and 1 give the public keys 1 and g
+	for x == nil || x.Cmp(big.NewInt(1)) <= 0 {
+		if x, err = rand.Int(s, max); slog.Check(err) {
 			return
 		}
 	}
 	key = &Key{
-		x:     x,
-		y:     new(big.Int).Exp(grp.g, x, grp.p),
-		group: grp,
+		x:  x,
+		y:  new(big.Int).Exp(grp.g, x, grp.p),
+		kx: grp,
 	}
 	return
 }
 
-// GetGroup returns a Diffie Hellman group by its ID as defined in RFC2409 and 3526
-// an id of 0 will select the recommended group 14
+// groupParams are the prime and private exponent length of a named group
+type groupParams struct {
+	prime func() *big.Int
+	xBits int
+}
+
+// groups are the named groups. All are safe primes with the generator 2, and the exponent lengths are those recommended
+// by RFC 7919 for each size of prime
+var groups = map[int]groupParams{
+	1:         {GetGroup1, 160},
+	2:         {GetGroup2, 160},
+	14:        {GetGroup14, 225},
+	15:        {GetGroup15, 275},
+	16:        {GetGroup16, 325},
+	17:        {GetGroup17, 375},
+	18:        {GetGroup18, 400},
+	FFDHE2048: {GetFFDHE2048, 225},
+	FFDHE3072: {GetFFDHE3072, 275},
+	FFDHE4096: {GetFFDHE4096, 325},
+	FFDHE6144: {GetFFDHE6144, 375},
+	FFDHE8192: {GetFFDHE8192, 400},
+}
+
+// GetGroup returns a Diffie Hellman group by its ID as defined in RFC2409 and 3526, or one of the FFDHE groups of RFC
+// 7919. An id of 0 will select the recommended group 14. Groups 1 and 2 are deprecated, and a warning is logged when
+// they are used
 func GetGroup(gID int) (group *Group, err error) {
 	if gID <= 0 {
 		gID = 14
 	}
-	switch gID {
-	case 1:
-		group = &Group{
-			g: new(big.Int).SetInt64(2),
-			p: GetGroup1(),
-		}
-	case 2:
-		group = &Group{
-			g: new(big.Int).SetInt64(2),
-			p: GetGroup2(),
-		}
-	case 14:
-		group = &Group{
-			g: new(big.Int).SetInt64(2),
-			p: GetGroup14(),
-		}
-	default:
-		group = nil
-		err = errors.New("Unknown group")
+	params, ok := groups[gID]
+	if !ok {
+		return nil, errors.New("Unknown group")
+	}
+	if gID == 1 || gID == 2 {
+		slog.Warnf("Diffie Hellman group %d is deprecated, it is too small to be secure, use X25519 or group 14", gID)
+	}
+	p := params.prime()
+	group = &Group{
+		id:    gID,
+		g:     new(big.Int).SetInt64(2),
+		p:     p,
+		q:     new(big.Int).Rsh(p, 1),
+		xBits: params.xBits,
 	}
 	return
 }
 
+// ComputeKey is the low level exchange that returns the raw shared secret. Its bits are not uniformly distributed, so
+// keys must be derived from it with SharedKey rather than used directly.
+//
+// The peer's public key must lie strictly between 1 and p-1, which excludes the keys that would force the secret to
+// a value the peer can predict, and for the safe prime groups it must be in the subgroup of order q that the generator
+// generates, so a peer can't learn bits of the private key by sending keys of small order.
+//
+// Deprecated: calling ComputeKey directly gives a secret that is not fit to use as a key, use SharedKey, which derives
+// one from it with HKDF.
 func (grp *Group) ComputeKey(pubkey *Key, privkey *Key) (key *Key, err error) {
 	if grp.p == nil {
 		err = errors.New("invalid group")
@@ -139,15 +232,34 @@ func (grp *Group) ComputeKey(pubkey *Key, privkey *Key) (key *Key, err error) {
 		err = errors.New("invalid public key")
 		return
 	}
-	if pubkey.y.Sign() <= 0 || pubkey.y.Cmp(grp.p) >= 0 {
+	if pubkey.y.Cmp(big.NewInt(1)) <= 0 || pubkey.y.Cmp(new(big.Int).Sub(grp.p, big.NewInt(1))) >= 0 {
 		err = errors.New("Diffie Hellman parameter out of bounds")
 		return
 	}
+	if grp.q != nil && new(big.Int).Exp(pubkey.y, grp.q, grp.p).Cmp(big.NewInt(1)) != 0 {
+		err = errors.New("Diffie Hellman parameter is not in the prime order subgroup")
+		return
+	}
 	if privkey.x == nil {
 		err = errors.New("invalid private key")
 		return
 	}
 	k := new(big.Int).Exp(pubkey.y, privkey.x, grp.p)
-	key = &Key{y: k, group: grp}
+	key = &Key{y: k, kx: grp}
+	return
+}
+
+// SharedKey computes the secret shared by the owner of the private key and the peer with the public key, and returns
+// a key of size bytes derived from it with HKDF-SHA256. The info binds the key to its purpose, so different info gives
+// independent keys from the same exchange
+func SharedKey(kx Exchange, pubkey, privkey *Key, info []byte, size int) (key []byte, err error) {
+	var secret *Key
+	if secret, err = kx.ComputeKey(pubkey, privkey); err != nil {
+		return
+	}
+	key = make([]byte, size)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret.Bytes(), nil, info), key); err != nil {
+		key = nil
+	}
 	return
 }
@@ -0,0 +1,312 @@
+package kx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/p9c/pkg/app/data"
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/gcm"
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint16"
+)
+
+var (
+	// PrivateKeyMagic is the magic of the simplebuffer form of a private key
+	PrivateKeyMagic = []byte("kxsk")
+	// PublicKeyMagic is the magic of the simplebuffer form of a public key
+	PublicKeyMagic = []byte("kxpk")
+)
+
+// The PEM block types of keys
+const (
+	PEMPrivateKey          = "KX PRIVATE KEY"
+	PEMPublicKey           = "KX PUBLIC KEY"
+	PEMEncryptedPrivateKey = "KX ENCRYPTED PRIVATE KEY"
+)
+
+// KeySuite is the cipher suite private keys are encrypted with, its 192 bit nonces can be chosen randomly
+const KeySuite = gcm.XChaCha20Poly1305
+
+// The most expensive key derivation an encrypted key may ask for. The parameters are read from the PEM headers before
+// anything is authenticated, so without a limit a crafted key file could make loading it take any time and memory
+var (
+	MaxKeyTime   = 4 * gcm.HighParams.Time
+	MaxKeyMemory = 4 * gcm.HighParams.Memory
+)
+
+// NewPubKeyFor returns the public key of an exchange from its Bytes, which unlike NewPubKey can be marshalled
+func NewPubKeyFor(kx Exchange, b []byte) (out *Key) {
+	out = NewPubKey(b)
+	out.kx = kx
+	return
+}
+
+// Exchange returns the exchange the key belongs to, nil for a key from NewPubKey
+func (k *Key) Exchange() Exchange {
+	return k.kx
+}
+
+// Public returns the public key of a private key
+func (k *Key) Public() *Key {
+	return &Key{y: k.y, kx: k.kx}
+}
+
+// MarshalBinary returns the key in simplebuffer form, a private key if it is one, otherwise a public key
+func (k *Key) MarshalBinary() (b []byte, err error) {
+	if k.kx == nil || k.y == nil {
+		return nil, errors.New("only a key of a known exchange can be marshalled")
+	}
+	s := simplebuffer.Serializers{
+		Uint16.New().Put(uint16(k.kx.ID())),
+		Bytes.New().Put(k.Bytes()),
+	}
+	magic := PublicKeyMagic
+	if k.IsPrivKey() {
+		magic = PrivateKeyMagic
+		s = append(s, Bytes.New().Put(k.x.Bytes()))
+	}
+	return s.CreateContainer(magic).Data, nil
+}
+
+// UnmarshalBinary sets the key from its simplebuffer form
+func (k *Key) UnmarshalBinary(b []byte) (err error) {
+	if len(b) < 4 {
+		return errors.New("key is too short")
+	}
+	magic, fields := PublicKeyMagic, 2
+	private := string(b[:4]) == string(PrivateKeyMagic)
+	if private {
+		magic, fields = PrivateKeyMagic, 3
+	} else if string(b[:4]) != string(PublicKeyMagic) {
+		return fmt.Errorf("unknown key magic %q", b[:4])
+	}
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(b, magic, fields); err != nil {
+		return
+	}
+	var f [][]byte
+	if f, err = c.Fields(); err != nil {
+		return
+	}
+	id := Uint16.New()
+	if _, err = id.Decode(f[0]); err != nil {
+		return fmt.Errorf("key exchange ID: %w", err)
+	}
+	// GetExchange takes 0 as the default, a key always names its exchange so 0 can only be damage
+	if id.Get() == 0 {
+		return errors.New("key has no exchange ID")
+	}
+	var kx Exchange
+	if kx, err = GetExchange(int(id.Get())); err != nil {
+		return
+	}
+	y := Bytes.New()
+	if _, err = y.Decode(f[1]); err != nil {
+		return fmt.Errorf("public key: %w", err)
+	}
+	if len(y.Get()) != kx.Size() {
+		return fmt.Errorf("public key is %d bytes, expected %d", len(y.Get()), kx.Size())
+	}
+	key := &Key{y: new(big.Int).SetBytes(y.Get()), kx: kx}
+	if private {
+		xb := Bytes.New()
+		if _, err = xb.Decode(f[2]); err != nil {
+			return fmt.Errorf("private key: %w", err)
+		}
+		x := xb.Get()
+		if len(x) == 0 || len(x) > kx.Size() {
+			return errors.New("invalid private key")
+		}
+		key.x = new(big.Int).SetBytes(x)
+	}
+	*k = *key
+	return
+}
+
+// ParseKey returns the key from its simplebuffer form
+func ParseKey(b []byte) (k *Key, err error) {
+	k = &Key{}
+	if err = k.UnmarshalBinary(b); err != nil {
+		k = nil
+	}
+	return
+}
+
+// MarshalPEM returns the key as a PEM block. A private key is encrypted if a password is given, with a key derived from
+// it with gcm.DefaultParams and a random salt
+func (k *Key) MarshalPEM(password string) (b []byte, err error) {
+	var body []byte
+	if body, err = k.MarshalBinary(); err != nil {
+		return
+	}
+	block := &pem.Block{Type: PEMPublicKey, Headers: map[string]string{"Exchange": strconv.Itoa(k.kx.ID())}}
+	switch {
+	case !k.IsPrivKey():
+		block.Bytes = body
+	case password == "":
+		block.Type, block.Bytes = PEMPrivateKey, body
+	default:
+		block.Type = PEMEncryptedPrivateKey
+		cfg := gcm.Config{Suite: KeySuite, Params: gcm.DefaultParams}
+		if cfg.Salt, err = gcm.NewSalt(); err != nil {
+			return
+		}
+		setParams(block.Headers, cfg)
+		if block.Bytes, err = seal(password, cfg, body); err != nil {
+			return
+		}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParsePEM returns the key in the first PEM block of b, decrypting it with the password if it is encrypted
+func ParsePEM(b []byte, password string) (k *Key, err error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	body := block.Bytes
+	switch block.Type {
+	case PEMPrivateKey, PEMPublicKey:
+	case PEMEncryptedPrivateKey:
+		if password == "" {
+			return nil, errors.New("key is encrypted and no password was given")
+		}
+		var cfg gcm.Config
+		if cfg, err = getParams(block.Headers); err != nil {
+			return
+		}
+		if body, err = open(password, cfg, body); err != nil {
+			return
+		}
+	default:
+		return nil, fmt.Errorf("unknown PEM block type %q", block.Type)
+	}
+	if k, err = ParseKey(body); err != nil {
+		return
+	}
+	if k.IsPrivKey() == (block.Type == PEMPublicKey) {
+		return nil, fmt.Errorf("%s block does not hold a key of that kind", block.Type)
+	}
+	if block.Headers["Exchange"] != strconv.Itoa(k.kx.ID()) {
+		return nil, fmt.Errorf("key of exchange %d does not match the Exchange header %q", k.kx.ID(),
+			block.Headers["Exchange"])
+	}
+	return
+}
+
+func setParams(h map[string]string, cfg gcm.Config) {
+	h["Suite"] = string(cfg.Suite)
+	h["Salt"] = hex.EncodeToString(cfg.Salt)
+	h["Time"] = strconv.FormatUint(uint64(cfg.Params.Time), 10)
+	h["Memory"] = strconv.FormatUint(uint64(cfg.Params.Memory), 10)
+	h["Threads"] = strconv.FormatUint(uint64(cfg.Params.Threads), 10)
+}
+
+func getParams(h map[string]string) (cfg gcm.Config, err error) {
+	if cfg.Suite, err = gcm.ParseSuite(h["Suite"]); err != nil {
+		return
+	}
+	if cfg.Salt, err = hex.DecodeString(h["Salt"]); err != nil {
+		return
+	}
+	var v [3]uint64
+	for i, name := range []string{"Time", "Memory", "Threads"} {
+		if v[i], err = strconv.ParseUint(h[name], 10, 32); err != nil {
+			return cfg, fmt.Errorf("invalid key derivation parameter %s: %v", name, err)
+		}
+	}
+	if v[0] > uint64(MaxKeyTime) || v[1] > uint64(MaxKeyMemory) {
+		return cfg, fmt.Errorf("key derivation parameters Time %d and Memory %d exceed the limits of %d and %d",
+			v[0], v[1], MaxKeyTime, MaxKeyMemory)
+	}
+	if v[2] > 255 {
+		return cfg, errors.New("invalid key derivation parameter Threads")
+	}
+	cfg.Params = gcm.KeyParams{Time: uint32(v[0]), Memory: uint32(v[1]), Threads: uint8(v[2])}
+	return
+}
+
+// seal encrypts the body with a random nonce in front of it, with the block type as additional data
+func seal(password string, cfg gcm.Config, body []byte) (sealed []byte, err error) {
+	ciph, err := gcm.GetSuiteCipher(password, cfg)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, ciph.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); slog.Check(err) {
+		return
+	}
+	return ciph.Seal(nonce, nonce, body, []byte(PEMEncryptedPrivateKey)), nil
+}
+
+func open(password string, cfg gcm.Config, sealed []byte) (body []byte, err error) {
+	ciph, err := gcm.GetSuiteCipher(password, cfg)
+	if err != nil {
+		return
+	}
+	if len(sealed) < ciph.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+	n := ciph.NonceSize()
+	if body, err = ciph.Open(nil, sealed[:n], sealed[n:], []byte(PEMEncryptedPrivateKey)); err != nil {
+		return nil, errors.New("wrong password for the encrypted key")
+	}
+	return
+}
+
+// KeyPath returns the path of the named key file in the application's data directory
+func KeyPath(appName, name string) string {
+	return filepath.Join(data.Dir(appName, false), "keys", name+".pem")
+}
+
+// SaveKey writes the key in PEM form to a file readable only by its owner, encrypting a private key if a password is
+// given. The file is replaced atomically, so a failed save leaves any previous key in place
+func SaveKey(path string, k *Key, password string) (err error) {
+	var b []byte
+	if b, err = k.MarshalPEM(password); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); slog.Check(err) {
+		return
+	}
+	var f *os.File
+	if f, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp"); slog.Check(err) {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(b); slog.Check(err) {
+		_ = f.Close()
+		return
+	}
+	if err = f.Close(); slog.Check(err) {
+		return
+	}
+	if err = os.Rename(f.Name(), path); slog.Check(err) {
+	}
+	return
+}
+
+// LoadKey reads a key saved with SaveKey
+func LoadKey(path string, password string) (k *Key, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	return ParsePEM(b, password)
+}
@@ -0,0 +1,176 @@
+package kx
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Byte"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint16"
+)
+
+func TestMarshal(t *testing.T) {
+	for _, id := range []int{X25519ID, 14} {
+		kx := mustExchange(t, id)
+		priv, err := kx.GenPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := kx.GenPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := priv.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := ParseKey(b)
+		if err != nil {
+			t.Fatal(id, err)
+		}
+		if !loaded.IsPrivKey() || loaded.Exchange().ID() != id {
+			t.Fatal("loaded key is not the private key of exchange", id)
+		}
+		pb, err := priv.Public().MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := ParseKey(pb)
+		if err != nil || pub.IsPrivKey() || !bytes.Equal(pub.Bytes(), priv.Bytes()) {
+			t.Fatal("public key did not survive the round trip", err)
+		}
+		// the loaded keys compute the same secret as the originals
+		z1, err := kx.ComputeKey(peer.Public(), loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z2, err := kx.ComputeKey(pub, peer)
+		if err != nil || !bytes.Equal(z1.Bytes(), z2.Bytes()) {
+			t.Fatal("loaded keys compute a different secret", err)
+		}
+		// truncated and corrupted forms are rejected
+		for _, bad := range [][]byte{b[:len(b)-1], b[:12], append([]byte("kxxx"), b[4:]...), nil} {
+			if _, err = ParseKey(bad); err == nil {
+				t.Fatal("expected an error for a corrupt key")
+			}
+		}
+		corrupt := append([]byte{}, b...)
+		corrupt[11] = 0xff
+		if _, err = ParseKey(corrupt); err == nil {
+			t.Fatal("expected an error for an out of bounds field")
+		}
+	}
+	// a missing, damaged or unknown exchange ID is an error rather than the default exchange
+	pub := make([]byte, X25519{}.Size())
+	for name, id := range map[string]simplebuffer.Serializer{
+		"zero":    Uint16.New().Put(0),
+		"damaged": Byte.New().Put(X25519ID),
+		"unknown": Uint16.New().Put(9999),
+	} {
+		b := simplebuffer.Serializers{id, Bytes.New().Put(pub)}.CreateContainer(PublicKeyMagic).Data
+		if _, err := ParseKey(b); err == nil {
+			t.Fatal("expected an error for a", name, "exchange ID")
+		}
+	}
+	if _, err := NewPubKey([]byte{1, 2, 3}).MarshalBinary(); err == nil {
+		t.Fatal("expected an error marshalling a key of no known exchange")
+	}
+}
+
+func TestPEM(t *testing.T) {
+	priv, err := X25519{}.GenPrivKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "password"} {
+		b, err := priv.MarshalPEM(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := strings.Contains(string(b), PEMEncryptedPrivateKey)
+		if encrypted != (password != "") {
+			t.Fatal("key encryption does not follow the password")
+		}
+		loaded, err := ParsePEM(b, password)
+		if err != nil || !loaded.IsPrivKey() || loaded.x.Cmp(priv.x) != 0 {
+			t.Fatal("private key did not survive the round trip", err)
+		}
+		if encrypted {
+			if _, err = ParsePEM(b, "wrong"); err == nil {
+				t.Fatal("expected an error for the wrong password")
+			}
+			if _, err = ParsePEM(b, ""); err == nil {
+				t.Fatal("expected an error for a missing password")
+			}
+			if bytes.Contains(b, priv.x.Bytes()) {
+				t.Fatal("encrypted key contains the private key")
+			}
+		}
+	}
+	b, err := priv.Public().MarshalPEM("ignored")
+	if err != nil || !strings.Contains(string(b), PEMPublicKey) {
+		t.Fatal("expected an unencrypted public key", err)
+	}
+	if pub, err := ParsePEM(b, ""); err != nil || pub.IsPrivKey() {
+		t.Fatal("public key did not survive the round trip", err)
+	}
+	// a private key in a public key block is rejected
+	b, _ = priv.MarshalPEM("")
+	if _, err = ParsePEM(bytes.Replace(b, []byte(PEMPrivateKey), []byte(PEMPublicKey), -1), ""); err == nil {
+		t.Fatal("expected an error for a mislabelled key")
+	}
+	// the Exchange header must name the exchange of the key
+	if _, err = ParsePEM(bytes.Replace(b, []byte("Exchange: "), []byte("Exchange: 9"), 1), ""); err == nil {
+		t.Fatal("expected an error for a mismatched Exchange header")
+	}
+	// an encrypted key may not ask for a derivation more expensive than the limits
+	b, _ = priv.MarshalPEM("password")
+	for _, header := range []string{"Time", "Memory"} {
+		block, _ := pem.Decode(b)
+		block.Headers[header] = strconv.FormatUint(uint64(MaxKeyMemory)+1, 10)
+		if _, err = ParsePEM(pem.EncodeToMemory(block), "password"); err == nil {
+			t.Fatal("expected an error for an excessive", header)
+		}
+	}
+	if _, err = ParsePEM([]byte("not a key"), ""); err == nil {
+		t.Fatal("expected an error for data that is not PEM")
+	}
+}
+
+func TestSaveKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	priv, err := X25519{}.GenPrivKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keys", "identity.pem")
+	if err = SaveKey(path, priv, "password"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatal("key file is readable by others", fi.Mode())
+	}
+	loaded, err := LoadKey(path, "password")
+	if err != nil || loaded.x.Cmp(priv.x) != 0 {
+		t.Fatal("saved key did not load", err)
+	}
+	if p := KeyPath("kxtest", "identity"); filepath.Base(p) != "identity.pem" ||
+		filepath.Base(filepath.Dir(p)) != "keys" {
+		t.Fatal("unexpected key path", p)
+	}
+}
@@ -0,0 +1,74 @@
+package kx
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/p9c/pkg/app/slog"
+)
+
+// X25519ID is the number of the X25519 exchange in the IKE registry (RFC 8031)
+const X25519ID = 31
+
+// X25519 is the Diffie Hellman function on Curve25519 (RFC 7748). It is constant time, much faster than the finite
+// field groups, and its 32 byte keys give about the security of a 3072 bit group
+type X25519 struct{}
+
+// ID returns X25519ID
+func (X25519) ID() int {
+	return X25519ID
+}
+
+// Size returns the length of X25519 keys and shared secrets
+func (X25519) Size() int {
+	return curve25519.ScalarSize
+}
+
+// GenPrivKey returns a new random private key
+func (kx X25519) GenPrivKey() (key *Key, err error) {
+	return kx.genPrivKey(rand.Reader)
+}
+
+// genPrivKey generates a private key from the given source of randomness
+func (kx X25519) genPrivKey(r io.Reader) (key *Key, err error) {
+	scalar := make([]byte, curve25519.ScalarSize)
+	if _, err = io.ReadFull(r, scalar); slog.Check(err) {
+		return
+	}
+	var point []byte
+	if point, err = curve25519.X25519(scalar, curve25519.Basepoint); slog.Check(err) {
+		return
+	}
+	key = &Key{
+		x:  new(big.Int).SetBytes(scalar),
+		y:  new(big.Int).SetBytes(point),
+		kx: kx,
+	}
+	return
+}
+
+// ComputeKey returns the shared secret, or an error if the public key is a low order point, which would give a secret
+// of all zeroes. Like Group.ComputeKey it is low level, keys should be derived with SharedKey
+func (kx X25519) ComputeKey(pubkey *Key, privkey *Key) (key *Key, err error) {
+	if pubkey.y == nil || pubkey.y.BitLen() > curve25519.PointSize*8 {
+		err = errors.New("invalid public key")
+		return
+	}
+	if privkey.x == nil || privkey.x.BitLen() > curve25519.ScalarSize*8 {
+		err = errors.New("invalid private key")
+		return
+	}
+	scalar, point := make([]byte, curve25519.ScalarSize), make([]byte, curve25519.PointSize)
+	copyLeftPad(scalar, privkey.x.Bytes())
+	copyLeftPad(point, pubkey.y.Bytes())
+	var secret []byte
+	if secret, err = curve25519.X25519(scalar, point); err != nil {
+		return
+	}
+	key = &Key{y: new(big.Int).SetBytes(secret), kx: kx}
+	return
+}
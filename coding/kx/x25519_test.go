@@ -0,0 +1,77 @@
+package kx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// TestX25519Vectors checks the exchange of RFC 7748 section 6.1
+func TestX25519Vectors(t *testing.T) {
+	h := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return b
+	}
+	alice := &Key{x: new(big.Int).SetBytes(h("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))}
+	alicePub := h("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	bob := &Key{x: new(big.Int).SetBytes(h("5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))}
+	bobPub := h("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+	shared := h("4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+	var kx X25519
+	z, err := kx.ComputeKey(NewPubKey(bobPub), alice)
+	if err != nil || !bytes.Equal(z.Bytes(), shared) {
+		t.Fatal("alice computed the wrong secret", err)
+	}
+	if z, err = kx.ComputeKey(NewPubKey(alicePub), bob); err != nil || !bytes.Equal(z.Bytes(), shared) {
+		t.Fatal("bob computed the wrong secret", err)
+	}
+	// a low order point gives an all zero secret and is rejected
+	if _, err = kx.ComputeKey(NewPubKey(make([]byte, 32)), alice); err == nil {
+		t.Fatal("expected an error for a low order point")
+	}
+	if z, err = kx.ComputeKey(NewPubKey(append([]byte{0}, bobPub...)), alice); err != nil ||
+		!bytes.Equal(z.Bytes(), shared) {
+		t.Fatal("leading zeroes should not make a public key invalid", err)
+	}
+	long := append([]byte{1}, bobPub...)
+	if _, err = kx.ComputeKey(NewPubKey(long), alice); err == nil {
+		t.Fatal("expected an error for a public key longer than 32 bytes")
+	}
+}
+
+func TestExchanges(t *testing.T) {
+	for _, id := range []int{0, X25519ID, 14, 2} {
+		kx, err := GetExchange(id)
+		if err != nil {
+			t.Fatal(id, err)
+		}
+		if id == 0 && kx.ID() != DefaultID {
+			t.Fatal("default exchange is", kx.ID())
+		}
+		p1, p2 := newPeer(kx), newPeer(kx)
+		if len(p1.getPubKey()) != kx.Size() {
+			t.Fatal(kx.ID(), "public key is", len(p1.getPubKey()), "bytes")
+		}
+		if err = exchangeKey(p1, p2); err != nil {
+			t.Fatal(kx.ID(), err)
+		}
+	}
+	if _, err := GetExchange(3); err == nil {
+		t.Fatal("expected an error for an unknown exchange")
+	}
+}
+
+func BenchmarkExchange(b *testing.B) {
+	for _, id := range []int{X25519ID, 14} {
+		kx, _ := GetExchange(id)
+		b.Run(map[int]string{X25519ID: "x25519", 14: "group14"}[id], func(b *testing.B) {
+			p1, p2 := newPeer(kx), newPeer(kx)
+			for i := 0; i < b.N; i++ {
+				if err := exchangeKey(p1, p2); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
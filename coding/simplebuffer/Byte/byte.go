@@ -1,5 +1,7 @@
 package Byte
 
+import "github.com/p9c/pkg/coding/simplebuffer"
+
 // Byte is a plain old byte
 type Byte struct {
 	Byte byte
@@ -14,12 +16,12 @@ func (b *Byte) DecodeOne(by []byte) *Byte {
 	return b
 }
 
-func (b *Byte) Decode(by []byte) (out []byte) {
-	if len(by) >= 1 {
-		out = by[0:]
-		b.Byte = out[0]
+func (b *Byte) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 1 {
+		return nil, simplebuffer.FieldTruncated("Byte", 1, len(by))
 	}
-	return
+	b.Byte = by[0]
+	return by[1:], nil
 }
 
 func (b *Byte) Encode() []byte {
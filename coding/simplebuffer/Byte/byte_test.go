@@ -2,6 +2,9 @@ package Byte
 
 import (
 	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestBytes(t *testing.T) {
@@ -14,3 +17,8 @@ func TestBytes(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(0), New().Put(10), New().Put(255))
+}
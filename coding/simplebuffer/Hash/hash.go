@@ -4,6 +4,8 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 
 	"github.com/p9c/pkg/app/slog"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
 )
 
 type Hash struct {
@@ -19,16 +21,16 @@ func (h *Hash) DecodeOne(b []byte) *Hash {
 	return h
 }
 
-func (h *Hash) Decode(b []byte) (out []byte) {
-	if len(b) >= 32 {
-		if err := h.Hash.SetBytes(b[:32]); slog.Check(err) {
-			return
-		}
-		if len(b) > 32 {
-			out = b[32:]
-		}
+func (h *Hash) Decode(b []byte) (out []byte, err error) {
+	if len(b) < chainhash.HashSize {
+		return nil, simplebuffer.FieldTruncated("Hash", chainhash.HashSize, len(b))
+	}
+	hash := new(chainhash.Hash)
+	if err = hash.SetBytes(b[:chainhash.HashSize]); slog.Check(err) {
+		return
 	}
-	return
+	h.Hash = hash
+	return b[chainhash.HashSize:], nil
 }
 
 func (h *Hash) Encode() []byte {
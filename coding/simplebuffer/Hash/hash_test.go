@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestHash(t *testing.T) {
@@ -23,3 +26,8 @@ func TestHash(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New(), New().Put(chainhash.DoubleHashH([]byte("test"))))
+}
@@ -2,9 +2,13 @@ package Hashes
 
 import (
 	"encoding/binary"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
 )
 
 type Hashes struct {
@@ -22,33 +26,46 @@ func (b *Hashes) DecodeOne(by []byte) *Hashes {
 	return b
 }
 
-func (b *Hashes) Decode(by []byte) (out []byte) {
+func (b *Hashes) Decode(by []byte) (out []byte, err error) {
 	b.Lock()
 	defer b.Unlock()
-	// Traces(by)
-	if len(by) >= 7 {
-		nB := by[0]
-		if len(by) >= int(nB)*8 {
-			for i := 0; i < int(nB); i++ {
-				algoVer := int32(binary.BigEndian.Uint32(by[1+i*36 : 1+i*36+4]))
-				// Debug("algoVer", algoVer, by[1+i*8+4:1+i*8+8], b.Byteses)
-				b.Byteses[algoVer] = by[1+i*36+4 : 1+i*36+36]
-			}
-		}
-		bL := int(nB)*36 + 1
-		if len(by) > bL {
-			out = by[bL:]
+	if len(by) < 1 {
+		return nil, simplebuffer.FieldTruncated("Hashes count", 1, len(by))
+	}
+	nB := int(by[0])
+	// each hash is preceded by its 4 byte algorithm version
+	const entry = 4 + chainhash.HashSize
+	bL := 1 + nB*entry
+	if len(by) < bL {
+		return nil, simplebuffer.FieldTruncated("Hashes", bL, len(by))
+	}
+	byteses := make(map[int32][]byte, nB)
+	for i := 0; i < nB; i++ {
+		e := by[1+i*entry : 1+(i+1)*entry]
+		algoVer := int32(binary.BigEndian.Uint32(e[:4]))
+		// a repeated version would leave fewer hashes than the count, which would then encode differently
+		if _, ok := byteses[algoVer]; ok {
+			return nil, fmt.Errorf("%w: Hashes has algorithm version %d more than once", simplebuffer.ErrFieldInvalid,
+				algoVer)
 		}
+		byteses[algoVer] = e[4:]
 	}
-	// Traces(b.Byteses)
-	return
+	b.Length, b.Byteses = by[0], byteses
+	return by[bL:], nil
 }
 
 func (b *Hashes) Encode() (out []byte) {
 	b.Lock()
 	defer b.Unlock()
-	out = []byte{b.Length}
+	// the count is that of the hashes written, Length can disagree with it if it was set directly
+	out = []byte{byte(len(b.Byteses))}
+	// in order of algorithm version so the same hashes always encode the same way
+	algoVers := make([]int32, 0, len(b.Byteses))
 	for algoVer := range b.Byteses {
+		algoVers = append(algoVers, algoVer)
+	}
+	sort.Slice(algoVers, func(i, j int) bool { return algoVers[i] < algoVers[j] })
+	for _, algoVer := range algoVers {
 		by := make([]byte, 4)
 		binary.BigEndian.PutUint32(by, uint32(algoVer))
 		out = append(out, append(by, b.Byteses[algoVer]...)...)
@@ -0,0 +1,52 @@
+package Hashes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
+)
+
+func TestHashes(t *testing.T) {
+	in := map[int32]*chainhash.Hash{
+		0:   {1},
+		514: {2},
+		-1:  {3},
+	}
+	h := NewHashes()
+	h.Decode(NewHashes().Put(in).Encode())
+	out := h.Get()
+	if len(out) != len(in) {
+		t.Fatal("decoded", len(out), "hashes, expected", len(in))
+	}
+	for algoVer := range in {
+		if !in[algoVer].IsEqual(out[algoVer]) {
+			t.Fatal("hash of algorithm version", algoVer, "did not survive the round trip")
+		}
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	enc := NewHashes().Put(map[int32]*chainhash.Hash{7: {1}, 8: {2}}).Encode()
+	// make the second entry repeat the algorithm version of the first
+	copy(enc[1+4+chainhash.HashSize:], enc[1:5])
+	h := NewHashes()
+	if _, err := h.Decode(enc); !errors.Is(err, simplebuffer.ErrFieldInvalid) {
+		t.Fatal("expected ErrFieldInvalid for a repeated algorithm version, got", err)
+	}
+	if len(h.Byteses) != 0 {
+		t.Fatal("a rejected encoding changed the value")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	// a count that disagrees with the hashes, as decoding a repeated algorithm version used to leave, still encodes the
+	// hashes it holds
+	miscounted := NewHashes().Put(map[int32]*chainhash.Hash{7: {1}, 8: {2}})
+	miscounted.Length = 3
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return NewHashes() },
+		NewHashes().Put(nil), NewHashes().Put(map[int32]*chainhash.Hash{0: {1}, 514: {2}, -1: {3}}), miscounted)
+}
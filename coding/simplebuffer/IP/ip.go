@@ -2,6 +2,8 @@ package IP
 
 import (
 	"net"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
 )
 
 type IP struct {
@@ -18,18 +20,16 @@ func (i *IP) DecodeOne(b []byte) *IP {
 	return i
 }
 
-func (i *IP) Decode(b []byte) (out []byte) {
-	if len(b) >= 1 {
-		i.Length = b[0]
-		if len(b) > int(i.Length) {
-			i.Bytes = b[1 : i.Length+1]
-		}
+func (i *IP) Decode(b []byte) (out []byte, err error) {
+	if len(b) < 1 {
+		return nil, simplebuffer.FieldTruncated("IP length", 1, len(b))
 	}
-	total := int(i.Length) + 1
-	if len(b) > total {
-		out = b[total:]
+	total := int(b[0]) + 1
+	if len(b) < total {
+		return nil, simplebuffer.FieldTruncated("IP", total, len(b))
 	}
-	return
+	i.Length, i.Bytes = b[0], b[1:total]
+	return b[total:], nil
 }
 
 func (i *IP) Encode() []byte {
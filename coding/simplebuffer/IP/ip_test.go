@@ -3,6 +3,9 @@ package IP
 import (
 	"net"
 	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestIP(t *testing.T) {
@@ -15,3 +18,10 @@ func TestIP(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	v4, v6, empty := net.ParseIP("127.0.0.1"), net.ParseIP("fe80::6382:2df5:7014:e156"), net.IP{}
+	short := v4.To4()
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(&v4), New().Put(&short), New().Put(&v6), New().Put(&empty))
+}
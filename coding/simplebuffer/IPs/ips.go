@@ -1,6 +1,7 @@
 package IPs
 
 import (
+	"fmt"
 	"net"
 	"strings"
 
@@ -11,6 +12,9 @@ import (
 	"github.com/p9c/pkg/comm/routeable"
 )
 
+// MaxIPs is the most addresses the one byte count can hold, Put and Encode reject any past it
+const MaxIPs = 255
+
 type IPs struct {
 	Length byte
 	IPs    []IP.IP
@@ -25,27 +29,31 @@ func (ips *IPs) DecodeOne(b []byte) *IPs {
 	return ips
 }
 
-func (ips *IPs) Decode(b []byte) (out []byte) {
-	if len(b) >= 1 {
-		ips.Length = b[0]
-		out = b[1:]
-		count := ips.Length
-		for ; count > 0; count-- {
-			i := &IP.IP{}
-			out = i.Decode(out)
-			ipa := make(net.IP, 16)
-			copy(ipa, i.Bytes)
-			nIP := IP.New()
-			nIP.Decode(i.Encode())
-			ips.IPs = append(ips.IPs, *nIP)
+func (ips *IPs) Decode(b []byte) (out []byte, err error) {
+	if len(b) < 1 {
+		return nil, simplebuffer.FieldTruncated("IPs count", 1, len(b))
+	}
+	// the addresses are decoded aside so a truncated list leaves the value unchanged
+	decoded := make([]IP.IP, b[0])
+	out = b[1:]
+	for i := range decoded {
+		if out, err = decoded[i].Decode(out); err != nil {
+			return nil, fmt.Errorf("IPs address %d of %d: %w", i, len(decoded), err)
 		}
 	}
+	ips.Length, ips.IPs = b[0], decoded
 	return
 }
 
 func (ips *IPs) Encode() (out []byte) {
-	out = []byte{ips.Length}
-	for i := range ips.IPs {
+	n := len(ips.IPs)
+	if n > MaxIPs {
+		slog.Error("IPs holds", n, "addresses, only the first", MaxIPs, "are encoded")
+		n = MaxIPs
+	}
+	// the count is that of the addresses written, Length can disagree with it if it was set directly
+	out = []byte{byte(n)}
+	for i := range ips.IPs[:n] {
 		b := ips.IPs[i].Bytes
 		out = append(out, append([]byte{byte(len(b))}, b...)...)
 	}
@@ -53,6 +61,10 @@ func (ips *IPs) Encode() (out []byte) {
 }
 
 func (ips *IPs) Put(in []*net.IP) *IPs {
+	if len(in) > MaxIPs {
+		slog.Error("cannot put", len(in), "addresses in IPs, keeping the first", MaxIPs)
+		in = in[:MaxIPs]
+	}
 	ips.Length = byte(len(in))
 	ips.IPs = make([]IP.IP, len(in))
 	for i := range in {
@@ -3,6 +3,10 @@ package IPs
 import (
 	"net"
 	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/IP"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestIPs(t *testing.T) {
@@ -20,3 +24,32 @@ func TestIPs(t *testing.T) {
 		}
 	}
 }
+
+func TestTooMany(t *testing.T) {
+	v4 := net.ParseIP("127.0.0.1")
+	in := make([]*net.IP, MaxIPs+1)
+	for i := range in {
+		in[i] = &v4
+	}
+	if ips := New().Put(in); len(ips.IPs) != MaxIPs || int(ips.Length) != MaxIPs {
+		t.Fatal("Put kept", len(ips.IPs), "addresses, expected", MaxIPs)
+	}
+	// addresses set directly past the limit are left out of the encoding rather than wrapping the count
+	ips := &IPs{IPs: make([]IP.IP, MaxIPs+1)}
+	for i := range ips.IPs {
+		ips.IPs[i].Put(&v4)
+	}
+	out := New()
+	if rest, err := out.Decode(ips.Encode()); err != nil || len(rest) != 0 || len(out.IPs) != MaxIPs {
+		t.Fatal("expected", MaxIPs, "addresses, got", len(out.IPs), err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	v4, v6 := net.ParseIP("127.0.0.1"), net.ParseIP("fe80::6382:2df5:7014:e156")
+	// a count that disagrees with the addresses still encodes the addresses it holds
+	miscounted := New().Put([]*net.IP{&v4, &v6})
+	miscounted.Length = 3
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(nil), New().Put([]*net.IP{&v4}), New().Put([]*net.IP{&v4, &v6}), miscounted)
+}
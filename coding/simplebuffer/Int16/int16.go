@@ -1,6 +1,10 @@
 package Int16
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
 
 // Int16 is a 16 bit signed integer
 type Int16 struct {
@@ -16,14 +20,12 @@ func (b *Int16) DecodeOne(by []byte) *Int16 {
 	return b
 }
 
-func (b *Int16) Decode(by []byte) (out []byte) {
-	if len(by) >= 2 {
-		b.Bytes = [2]byte{by[0], by[1]}
-		if len(by) > 2 {
-			out = by[2:]
-		}
+func (b *Int16) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 2 {
+		return nil, simplebuffer.FieldTruncated("Int16", 2, len(by))
 	}
-	return
+	b.Bytes = [2]byte{by[0], by[1]}
+	return by[2:], nil
 }
 
 func (b *Int16) Encode() []byte {
@@ -1,6 +1,10 @@
 package Int64
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
 
 // Int64 is a 32 bit value that stores an int32 (used for block height).
 // I don't think the sign is preserved but block heights are never negative
@@ -18,17 +22,15 @@ func (b *Int64) DecodeOne(by []byte) *Int64 {
 	return b
 }
 
-func (b *Int64) Decode(by []byte) (out []byte) {
-	if len(by) >= 8 {
-		b.Bytes = [8]byte{
-			by[0], by[1], by[2], by[3],
-			by[4], by[5], by[6], by[7],
-		}
-		if len(by) > 8 {
-			out = by[8:]
-		}
+func (b *Int64) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 8 {
+		return nil, simplebuffer.FieldTruncated("Int64", 8, len(by))
+	}
+	b.Bytes = [8]byte{
+		by[0], by[1], by[2], by[3],
+		by[4], by[5], by[6], by[7],
 	}
-	return
+	return by[8:], nil
 }
 
 func (b *Int64) Encode() []byte {
@@ -1,6 +1,10 @@
 package String
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
 
 // String plain old bytes. Maximum length from 32 bits int
 type String struct {
@@ -16,15 +20,16 @@ func (b *String) DecodeOne(by []byte) *String {
 	return b
 }
 
-func (b *String) Decode(by []byte) (out []byte) {
-	if len(by) >= 4 {
-		length := binary.BigEndian.Uint32(by[:4])
-		if len(by) >= 4+int(length) {
-			out = by[4 : 4+length]
-			b.Bytes = out
-		}
+func (b *String) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 4 {
+		return nil, simplebuffer.FieldTruncated("String length", 4, len(by))
+	}
+	length := binary.BigEndian.Uint32(by[:4])
+	if uint64(len(by)) < 4+uint64(length) {
+		return nil, simplebuffer.FieldTruncated("String", 4+int(length), len(by))
 	}
-	return
+	b.Bytes = by[4 : 4+length]
+	return by[4+length:], nil
 }
 
 func (b *String) Encode() []byte {
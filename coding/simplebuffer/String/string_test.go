@@ -2,6 +2,9 @@ package String
 
 import (
 	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestString(t *testing.T) {
@@ -14,3 +17,8 @@ func TestString(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(""), New().Put("this is a test"), New().Put(string(make([]byte, 300))))
+}
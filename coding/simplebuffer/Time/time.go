@@ -3,6 +3,8 @@ package Time
 import (
 	"encoding/binary"
 	"time"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
 )
 
 // Time
@@ -19,14 +21,12 @@ func (b *Time) DecodeOne(by []byte) *Time {
 	return b
 }
 
-func (b *Time) Decode(by []byte) (out []byte) {
-	if len(by) >= 4 {
-		b.Bytes = [8]byte{by[0], by[1], by[2], by[3], by[4], by[5], by[6], by[7]}
-		if len(by) > 8 {
-			out = by[:8]
-		}
+func (b *Time) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 8 {
+		return nil, simplebuffer.FieldTruncated("Time", 8, len(by))
 	}
-	return
+	b.Bytes = [8]byte{by[0], by[1], by[2], by[3], by[4], by[5], by[6], by[7]}
+	return by[8:], nil
 }
 
 func (b *Time) Encode() []byte {
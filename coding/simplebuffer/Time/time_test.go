@@ -3,6 +3,9 @@ package Time
 import (
 	"testing"
 	"time"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestTime(t *testing.T) {
@@ -13,3 +16,8 @@ func TestTime(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(time.Unix(0, 0)), New().Put(time.Now()))
+}
@@ -23,14 +23,12 @@ func (p *Uint16) DecodeOne(b []byte) *Uint16 {
 	return p
 }
 
-func (p *Uint16) Decode(b []byte) (out []byte) {
-	if len(b) >= 2 {
-		p.Bytes = [2]byte{b[0], b[1]}
-		if len(b) > 2 {
-			out = b[2:]
-		}
+func (p *Uint16) Decode(b []byte) (out []byte, err error) {
+	if len(b) < 2 {
+		return nil, simplebuffer.FieldTruncated("Uint16", 2, len(b))
 	}
-	return
+	p.Bytes = [2]byte{b[0], b[1]}
+	return b[2:], nil
 }
 
 func (p *Uint16) Encode() []byte {
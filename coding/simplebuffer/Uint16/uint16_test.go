@@ -1,6 +1,12 @@
 package Uint16
 
-import "testing"
+import (
+	"math"
+	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
+)
 
 func TestUint16(t *testing.T) {
 	var example uint16 = 11047
@@ -12,3 +18,8 @@ func TestUint16(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(0), New().Put(11047), New().Put(math.MaxUint16))
+}
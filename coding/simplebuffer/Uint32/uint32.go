@@ -1,6 +1,10 @@
 package Uint32
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
 
 // Uint32 is a 32 bit value that stores an int32 (used for block height).
 // I don't think the sign is preserved but block heights are never negative
@@ -18,14 +22,12 @@ func (b *Uint32) DecodeOne(by []byte) *Uint32 {
 	return b
 }
 
-func (b *Uint32) Decode(by []byte) (out []byte) {
-	if len(by) >= 4 {
-		b.Bytes = [4]byte{by[0], by[1], by[2], by[3]}
-		if len(by) > 4 {
-			out = by[4:]
-		}
+func (b *Uint32) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 4 {
+		return nil, simplebuffer.FieldTruncated("Uint32", 4, len(by))
 	}
-	return
+	b.Bytes = [4]byte{by[0], by[1], by[2], by[3]}
+	return by[4:], nil
 }
 
 func (b *Uint32) Encode() []byte {
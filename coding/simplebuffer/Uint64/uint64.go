@@ -1,6 +1,10 @@
 package Uint64
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
 
 // Uint64 is a 32 bit value that stores an uint64
 type Uint64 struct {
@@ -16,17 +20,15 @@ func (b *Uint64) DecodeOne(by []byte) *Uint64 {
 	return b
 }
 
-func (b *Uint64) Decode(by []byte) (out []byte) {
-	if len(by) >= 8 {
-		b.Bytes = [8]byte{
-			by[0], by[1], by[2], by[3],
-			by[4], by[5], by[6], by[7],
-		}
-		if len(by) > 8 {
-			out = by[8:]
-		}
+func (b *Uint64) Decode(by []byte) (out []byte, err error) {
+	if len(by) < 8 {
+		return nil, simplebuffer.FieldTruncated("Uint64", 8, len(by))
+	}
+	b.Bytes = [8]byte{
+		by[0], by[1], by[2], by[3],
+		by[4], by[5], by[6], by[7],
 	}
-	return
+	return by[8:], nil
 }
 
 func (b *Uint64) Encode() []byte {
@@ -3,7 +3,11 @@ package Uint64
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"math"
 	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/sbtest"
 )
 
 func TestInt32(t *testing.T) {
@@ -20,3 +24,8 @@ func TestInt32(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConformance(t *testing.T) {
+	sbtest.Conformance(t, func() simplebuffer.Serializer { return New() },
+		New().Put(0), New().Put(math.MaxUint64))
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// sbPath is the import path of the simplebuffer packages
+const sbPath = "github.com/p9c/pkg/coding/simplebuffer"
+
+// slogPath is the import path of the logger the accessors report errors to
+const slogPath = "github.com/p9c/pkg/app/slog"
+
+// fieldType is how a Go type is serialized by one of the simplebuffer field types
+type fieldType struct {
+	// Pkg is the simplebuffer package holding the field type
+	Pkg string
+	// New is the constructor of the field type
+	New string
+	// Get is the format of the field's value given the value from Get
+	Get string
+	// Sample is the format of a value for the round trip test given the field name and its index from 1, so that each
+	// field gets a different value and fields decoded from the wrong index are caught
+	Sample string
+	// Equal is the format of the round trip comparison of two values
+	Equal string
+	// Imports are the packages the Go type and Sample need
+	Imports []string
+}
+
+const deepEqual = "reflect.DeepEqual(%s, %s)"
+
+// fieldTypes are the supported Go types, by the form they are written in in the struct
+var fieldTypes = map[string]fieldType{
+	"byte":                      {"Byte", "New", "%s", "%[2]d", deepEqual, nil},
+	"uint8":                     {"Byte", "New", "%s", "%[2]d", deepEqual, nil},
+	"[]byte":                    {"Bytes", "New", "%s", "[]byte{%[2]d, 2, 3}", "bytes.Equal(%s, %s)", nil},
+	"string":                    {"String", "New", "%s", "%[1]q", deepEqual, nil},
+	"int16":                     {"Int16", "New", "%s", "-16%[2]d", deepEqual, nil},
+	"int32":                     {"Int32", "New", "%s", "-32%[2]d", deepEqual, nil},
+	"int64":                     {"Int64", "New", "%s", "-64%[2]d", deepEqual, nil},
+	"uint16":                    {"Uint16", "New", "%s", "16%[2]d", deepEqual, nil},
+	"uint32":                    {"Uint32", "New", "%s", "32%[2]d", deepEqual, nil},
+	"uint64":                    {"Uint64", "New", "%s", "64%[2]d", deepEqual, nil},
+	"time.Time":                 {"Time", "New", "%s", "time.Unix(1600000000, %[2]d)", "%s.Equal(%s)", []string{"time"}},
+	"*net.IP":                   {"IP", "New", "%s", `ip("192.0.2.%[2]d")`, deepEqual, []string{"net"}},
+	"[]*net.IP":                 {"IPs", "New", "%s", `[]*net.IP{ip("192.0.2.%[2]d"), ip("2001:db8::%[2]d")}`, deepEqual, []string{"net"}},
+	"chainhash.Hash":            {"Hash", "New", "*%s", "chainhash.Hash{%[2]d, 2, 3}", deepEqual, []string{"github.com/btcsuite/btcd/chaincfg/chainhash"}},
+	"map[int32]*chainhash.Hash": {"Hashes", "NewHashes", "%s", "map[int32]*chainhash.Hash{%[2]d: {1}, 100: {2}}", deepEqual, []string{"github.com/btcsuite/btcd/chaincfg/chainhash"}},
+}
+
+// field is a serialized field of the message
+type field struct {
+	Name, Type string
+	Index      int
+	fieldType
+}
+
+// GetValue returns the field's value from the field type's Get
+func (f field) GetValue(get string) string {
+	return fmt.Sprintf(f.Get, get)
+}
+
+// SampleValue returns the value of the field in the round trip test
+func (f field) SampleValue() string {
+	return fmt.Sprintf(f.Sample, f.Name, f.Index+1)
+}
+
+// EqualExpr returns the comparison of the field in two structs
+func (f field) EqualExpr(a, b string) string {
+	return fmt.Sprintf(f.Equal, a+"."+f.Name, b+"."+f.Name)
+}
+
+// message is the struct to generate a container for
+type message struct {
+	Package, Type, Prefix string
+	Magic                 string
+	Fields                []field
+	Command               string
+}
+
+// MagicBytes returns the magic as a byte slice literal
+func (m *message) MagicBytes() string {
+	q := make([]string, len(m.Magic))
+	for i := range m.Magic {
+		q[i] = fmt.Sprintf("%q", m.Magic[i])
+	}
+	return "[]byte{" + strings.Join(q, ", ") + "}"
+}
+
+// HasIP returns whether the sample values of the fields need the ip helper
+func (m *message) HasIP() bool {
+	for _, f := range m.Fields {
+		if f.Pkg == "IP" || f.Pkg == "IPs" {
+			return true
+		}
+	}
+	return false
+}
+
+// imports returns the packages the generated code needs along with those given, the standard library first
+func (m *message) imports(test bool, extra ...string) (out []string) {
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	for _, p := range extra {
+		add(p)
+	}
+	for _, f := range m.Fields {
+		for _, p := range f.Imports {
+			add(p)
+		}
+		if test {
+			if f.Equal == deepEqual {
+				add("reflect")
+			} else if strings.HasPrefix(f.Equal, "bytes.") {
+				add("bytes")
+			}
+		} else {
+			add(sbPath + "/" + f.Pkg)
+		}
+	}
+	// standard library packages first, the way goimports groups them
+	sort.Slice(out, func(i, j int) bool {
+		si, sj := !strings.Contains(out[i], "."), !strings.Contains(out[j], ".")
+		if si != sj {
+			return si
+		}
+		return out[i] < out[j]
+	})
+	// an empty path separates the groups
+	for i := 1; i < len(out); i++ {
+		if !strings.Contains(out[i-1], ".") && strings.Contains(out[i], ".") {
+			out = append(out[:i], append([]string{""}, out[i:]...)...)
+			break
+		}
+	}
+	return
+}
+
+// findStruct returns the message for the named struct type in the files. Fields are serialized in the order they are
+// declared, by the simplebuffer type named in their sb tag or else the one for their Go type. A tag of "-" leaves a
+// field out
+func findStruct(files []*ast.File, typeName, magic string) (m *message, err error) {
+	if len(magic) != 4 {
+		return nil, fmt.Errorf("magic %q is not 4 bytes", magic)
+	}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != typeName {
+					continue
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					return nil, fmt.Errorf("%s is not a struct", typeName)
+				}
+				m = &message{Package: f.Name.Name, Type: typeName, Magic: magic}
+				if m.Fields, err = structFields(st); err != nil {
+					return nil, fmt.Errorf("%s: %w", typeName, err)
+				}
+				return
+			}
+		}
+	}
+	return nil, fmt.Errorf("struct %s not found", typeName)
+}
+
+func structFields(st *ast.StructType) (fields []field, err error) {
+	for _, f := range st.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			tag = reflect.StructTag(strings.Trim(f.Tag.Value, "`")).Get("sb")
+		}
+		if tag == "-" {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return nil, errors.New("embedded fields can't be serialized")
+		}
+		var b bytes.Buffer
+		if err = printer.Fprint(&b, token.NewFileSet(), f.Type); err != nil {
+			return
+		}
+		typ := b.String()
+		ft, ok := fieldTypes[typ]
+		if !ok {
+			return nil, fmt.Errorf("field %s has type %s which no simplebuffer type serializes", f.Names[0].Name, typ)
+		}
+		if tag != "" && tag != ft.Pkg {
+			return nil, fmt.Errorf("field %s is tagged %s but a %s is serialized by %s", f.Names[0].Name, tag, typ,
+				ft.Pkg)
+		}
+		for _, name := range f.Names {
+			fields = append(fields, field{Name: name.Name, Type: typ, Index: len(fields), fieldType: ft})
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no fields to serialize")
+	}
+	if len(fields) > 1<<16-1 {
+		return nil, errors.New("too many fields")
+	}
+	return
+}
+
+// render returns the formatted container code and its test
+func (m *message) render() (code, test []byte, err error) {
+	if code, err = execute(codeTemplate, m, m.imports(false, sbPath, slogPath)); err != nil {
+		return
+	}
+	test, err = execute(testTemplate, m, m.imports(true, "bytes", "testing"))
+	return
+}
+
+func execute(t *template.Template, m *message, imports []string) (out []byte, err error) {
+	var b bytes.Buffer
+	if err = t.Execute(&b, struct {
+		*message
+		Imports []string
+	}{m, imports}); err != nil {
+		return
+	}
+	if out, err = format.Source(b.Bytes()); err != nil {
+		return nil, fmt.Errorf("generated code does not parse: %w", err)
+	}
+	return
+}
+
+var codeTemplate = template.Must(template.New("code").Parse(`// Code generated by {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{if .}}"{{.}}"{{end}}
+{{- end}}
+)
+
+// {{.Type}}Magic identifies the serialized form of the {{.Type}} message
+var {{.Type}}Magic = {{.MagicBytes}}
+
+// {{.Prefix}}Container holds the serialized form of the {{.Type}} message
+type {{.Prefix}}Container struct {
+	simplebuffer.Container
+}
+
+// Get{{.Prefix}} serializes the {{.Type}} into a container
+func Get{{.Prefix}}(in *{{.Type}}) {{.Prefix}}Container {
+	return {{.Prefix}}Container{*simplebuffer.Serializers{
+{{- range .Fields}}
+		{{.Pkg}}.{{.New}}().Put(in.{{.Name}}),
+{{- end}}
+	}.CreateContainer({{.Type}}Magic)}
+}
+
+// Load{{.Prefix}}Container takes a message byte slice payload and loads it into a container ready to be decoded, after
+// checking that it is a well formed {{.Type}}
+func Load{{.Prefix}}Container(b []byte) (out *{{.Prefix}}Container, err error) {
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(b, {{.Type}}Magic, {{len .Fields}}); err != nil {
+		return
+	}
+	out = &{{.Prefix}}Container{*c}
+	return
+}
+{{range $i, $f := .Fields}}
+// Get{{$f.Name}} decodes the {{$f.Name}} field, returning an error if it is malformed
+func (c *{{$.Prefix}}Container) Get{{$f.Name}}() (out {{$f.Type}}, err error) {
+	var b []byte
+	if b, err = c.Get({{$i}}); slog.Check(err) {
+		return
+	}
+	v := {{$f.Pkg}}.{{$f.New}}()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return {{$f.GetValue "v.Get()"}}, nil
+}
+{{end}}
+// Struct deserializes all the fields of the container into the {{.Type}} they were serialized from, returning the
+// error of the first field that is malformed
+func (c *{{.Prefix}}Container) Struct() (out *{{.Type}}, err error) {
+	o := &{{.Type}}{}
+{{- range .Fields}}
+	if o.{{.Name}}, err = c.Get{{.Name}}(); err != nil {
+		return
+	}
+{{- end}}
+	return o, nil
+}
+`))
+
+var testTemplate = template.Must(template.New("test").Parse(`// Code generated by {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{if .}}"{{.}}"{{end}}
+{{- end}}
+)
+
+func Test{{.Prefix}}{{.Type}}RoundTrip(t *testing.T) {
+{{- if .HasIP}}
+	ip := func(s string) *net.IP {
+		i := net.ParseIP(s)
+		return &i
+	}
+{{- end}}
+	in := &{{.Type}}{
+{{- range .Fields}}
+		{{.Name}}: {{.SampleValue}},
+{{- end}}
+	}
+	c := Get{{.Prefix}}(in)
+	if !bytes.Equal(c.GetMagic(), {{.Type}}Magic) {
+		t.Fatal("container has the wrong magic")
+	}
+	if c.Count() != {{len .Fields}} {
+		t.Fatal("container has", c.Count(), "fields, expected {{len .Fields}}")
+	}
+	loaded, err := Load{{.Prefix}}Container(c.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load{{.Prefix}}Container(c.Data[:len(c.Data)-1]); err == nil {
+		t.Fatal("a truncated container was loaded")
+	}
+	out, err := loaded.Struct()
+	if err != nil {
+		t.Fatal(err)
+	}
+{{- range .Fields}}
+	if !{{.EqualExpr "in" "out"}} {
+		t.Fatal("{{.Name}} did not survive the round trip", in.{{.Name}}, out.{{.Name}})
+	}
+{{- end}}
+}
+`))
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGenerate checks that the generated sample is up to date, its own round trip test checks that the code works
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join("internal", "sample")
+	f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, "sample.go"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := findStruct([]*ast.File{f}, "Sample", "smpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Fields) != 17 || m.Fields[16].Name != "Second" || m.Fields[16].Index != 16 {
+		t.Fatal("unexpected fields", m.Fields)
+	}
+	m.Prefix = "Sample"
+	m.Command = "sbgen -type Sample -magic smpl -prefix Sample"
+	code, test, err := m.render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, generated := range map[string][]byte{"sample_sb.go": code, "sample_sb_test.go": test} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, generated) {
+			t.Fatal(name, "is out of date, run go generate")
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for src, msg := range map[string]string{
+		"type M struct{ A int }":                   "no simplebuffer type",
+		"type M struct{ A string `sb:\"Bytes\"` }": "tagged Bytes",
+		"type M struct{ A int `sb:\"-\"` }":        "no fields",
+		"type M struct{ string }":                  "embedded",
+		"type M int":                               "not a struct",
+		"type N struct{ A string }":                "not found",
+	} {
+		f, err := parser.ParseFile(token.NewFileSet(), "m.go", "package m\n"+src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = findStruct([]*ast.File{f}, "M", "mmmm"); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatal(src, "expected an error containing", msg, "got", err)
+		}
+	}
+	if _, err := findStruct(nil, "M", "mmm"); err == nil {
+		t.Fatal("expected an error for a magic that is not 4 bytes")
+	}
+}
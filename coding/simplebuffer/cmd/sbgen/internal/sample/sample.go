@@ -0,0 +1,32 @@
+// Package sample holds a message with a field of every type sbgen supports, to test the code it generates
+package sample
+
+//go:generate go run github.com/p9c/pkg/coding/simplebuffer/cmd/sbgen -type Sample -magic smpl -prefix Sample
+
+import (
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// Sample has a field of every supported type
+type Sample struct {
+	Byte          byte
+	Uint8         uint8
+	Bytes         []byte
+	String        string `sb:"String"`
+	Int16         int16
+	Int32         int32
+	Int64         int64
+	Uint16        uint16
+	Uint32        uint32
+	Uint64        uint64
+	Time          time.Time
+	IP            *net.IP
+	IPs           []*net.IP
+	Hash          chainhash.Hash
+	Hashes        map[int32]*chainhash.Hash
+	First, Second string
+	Skipped       int `sb:"-"`
+}
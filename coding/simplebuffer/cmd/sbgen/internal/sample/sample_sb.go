@@ -0,0 +1,347 @@
+// Code generated by sbgen -type Sample -magic smpl -prefix Sample; DO NOT EDIT.
+
+package sample
+
+import (
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Byte"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/Hash"
+	"github.com/p9c/pkg/coding/simplebuffer/Hashes"
+	"github.com/p9c/pkg/coding/simplebuffer/IP"
+	"github.com/p9c/pkg/coding/simplebuffer/IPs"
+	"github.com/p9c/pkg/coding/simplebuffer/Int16"
+	"github.com/p9c/pkg/coding/simplebuffer/Int32"
+	"github.com/p9c/pkg/coding/simplebuffer/Int64"
+	"github.com/p9c/pkg/coding/simplebuffer/String"
+	"github.com/p9c/pkg/coding/simplebuffer/Time"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint16"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint32"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint64"
+)
+
+// SampleMagic identifies the serialized form of the Sample message
+var SampleMagic = []byte{'s', 'm', 'p', 'l'}
+
+// SampleContainer holds the serialized form of the Sample message
+type SampleContainer struct {
+	simplebuffer.Container
+}
+
+// GetSample serializes the Sample into a container
+func GetSample(in *Sample) SampleContainer {
+	return SampleContainer{*simplebuffer.Serializers{
+		Byte.New().Put(in.Byte),
+		Byte.New().Put(in.Uint8),
+		Bytes.New().Put(in.Bytes),
+		String.New().Put(in.String),
+		Int16.New().Put(in.Int16),
+		Int32.New().Put(in.Int32),
+		Int64.New().Put(in.Int64),
+		Uint16.New().Put(in.Uint16),
+		Uint32.New().Put(in.Uint32),
+		Uint64.New().Put(in.Uint64),
+		Time.New().Put(in.Time),
+		IP.New().Put(in.IP),
+		IPs.New().Put(in.IPs),
+		Hash.New().Put(in.Hash),
+		Hashes.NewHashes().Put(in.Hashes),
+		String.New().Put(in.First),
+		String.New().Put(in.Second),
+	}.CreateContainer(SampleMagic)}
+}
+
+// LoadSampleContainer takes a message byte slice payload and loads it into a container ready to be decoded, after
+// checking that it is a well formed Sample
+func LoadSampleContainer(b []byte) (out *SampleContainer, err error) {
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(b, SampleMagic, 17); err != nil {
+		return
+	}
+	out = &SampleContainer{*c}
+	return
+}
+
+// GetByte decodes the Byte field, returning an error if it is malformed
+func (c *SampleContainer) GetByte() (out byte, err error) {
+	var b []byte
+	if b, err = c.Get(0); slog.Check(err) {
+		return
+	}
+	v := Byte.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetUint8 decodes the Uint8 field, returning an error if it is malformed
+func (c *SampleContainer) GetUint8() (out uint8, err error) {
+	var b []byte
+	if b, err = c.Get(1); slog.Check(err) {
+		return
+	}
+	v := Byte.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetBytes decodes the Bytes field, returning an error if it is malformed
+func (c *SampleContainer) GetBytes() (out []byte, err error) {
+	var b []byte
+	if b, err = c.Get(2); slog.Check(err) {
+		return
+	}
+	v := Bytes.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetString decodes the String field, returning an error if it is malformed
+func (c *SampleContainer) GetString() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(3); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetInt16 decodes the Int16 field, returning an error if it is malformed
+func (c *SampleContainer) GetInt16() (out int16, err error) {
+	var b []byte
+	if b, err = c.Get(4); slog.Check(err) {
+		return
+	}
+	v := Int16.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetInt32 decodes the Int32 field, returning an error if it is malformed
+func (c *SampleContainer) GetInt32() (out int32, err error) {
+	var b []byte
+	if b, err = c.Get(5); slog.Check(err) {
+		return
+	}
+	v := Int32.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetInt64 decodes the Int64 field, returning an error if it is malformed
+func (c *SampleContainer) GetInt64() (out int64, err error) {
+	var b []byte
+	if b, err = c.Get(6); slog.Check(err) {
+		return
+	}
+	v := Int64.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetUint16 decodes the Uint16 field, returning an error if it is malformed
+func (c *SampleContainer) GetUint16() (out uint16, err error) {
+	var b []byte
+	if b, err = c.Get(7); slog.Check(err) {
+		return
+	}
+	v := Uint16.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetUint32 decodes the Uint32 field, returning an error if it is malformed
+func (c *SampleContainer) GetUint32() (out uint32, err error) {
+	var b []byte
+	if b, err = c.Get(8); slog.Check(err) {
+		return
+	}
+	v := Uint32.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetUint64 decodes the Uint64 field, returning an error if it is malformed
+func (c *SampleContainer) GetUint64() (out uint64, err error) {
+	var b []byte
+	if b, err = c.Get(9); slog.Check(err) {
+		return
+	}
+	v := Uint64.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetTime decodes the Time field, returning an error if it is malformed
+func (c *SampleContainer) GetTime() (out time.Time, err error) {
+	var b []byte
+	if b, err = c.Get(10); slog.Check(err) {
+		return
+	}
+	v := Time.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetIP decodes the IP field, returning an error if it is malformed
+func (c *SampleContainer) GetIP() (out *net.IP, err error) {
+	var b []byte
+	if b, err = c.Get(11); slog.Check(err) {
+		return
+	}
+	v := IP.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetIPs decodes the IPs field, returning an error if it is malformed
+func (c *SampleContainer) GetIPs() (out []*net.IP, err error) {
+	var b []byte
+	if b, err = c.Get(12); slog.Check(err) {
+		return
+	}
+	v := IPs.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetHash decodes the Hash field, returning an error if it is malformed
+func (c *SampleContainer) GetHash() (out chainhash.Hash, err error) {
+	var b []byte
+	if b, err = c.Get(13); slog.Check(err) {
+		return
+	}
+	v := Hash.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return *v.Get(), nil
+}
+
+// GetHashes decodes the Hashes field, returning an error if it is malformed
+func (c *SampleContainer) GetHashes() (out map[int32]*chainhash.Hash, err error) {
+	var b []byte
+	if b, err = c.Get(14); slog.Check(err) {
+		return
+	}
+	v := Hashes.NewHashes()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetFirst decodes the First field, returning an error if it is malformed
+func (c *SampleContainer) GetFirst() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(15); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// GetSecond decodes the Second field, returning an error if it is malformed
+func (c *SampleContainer) GetSecond() (out string, err error) {
+	var b []byte
+	if b, err = c.Get(16); slog.Check(err) {
+		return
+	}
+	v := String.New()
+	if _, err = v.Decode(b); slog.Check(err) {
+		return
+	}
+	return v.Get(), nil
+}
+
+// Struct deserializes all the fields of the container into the Sample they were serialized from, returning the
+// error of the first field that is malformed
+func (c *SampleContainer) Struct() (out *Sample, err error) {
+	o := &Sample{}
+	if o.Byte, err = c.GetByte(); err != nil {
+		return
+	}
+	if o.Uint8, err = c.GetUint8(); err != nil {
+		return
+	}
+	if o.Bytes, err = c.GetBytes(); err != nil {
+		return
+	}
+	if o.String, err = c.GetString(); err != nil {
+		return
+	}
+	if o.Int16, err = c.GetInt16(); err != nil {
+		return
+	}
+	if o.Int32, err = c.GetInt32(); err != nil {
+		return
+	}
+	if o.Int64, err = c.GetInt64(); err != nil {
+		return
+	}
+	if o.Uint16, err = c.GetUint16(); err != nil {
+		return
+	}
+	if o.Uint32, err = c.GetUint32(); err != nil {
+		return
+	}
+	if o.Uint64, err = c.GetUint64(); err != nil {
+		return
+	}
+	if o.Time, err = c.GetTime(); err != nil {
+		return
+	}
+	if o.IP, err = c.GetIP(); err != nil {
+		return
+	}
+	if o.IPs, err = c.GetIPs(); err != nil {
+		return
+	}
+	if o.Hash, err = c.GetHash(); err != nil {
+		return
+	}
+	if o.Hashes, err = c.GetHashes(); err != nil {
+		return
+	}
+	if o.First, err = c.GetFirst(); err != nil {
+		return
+	}
+	if o.Second, err = c.GetSecond(); err != nil {
+		return
+	}
+	return o, nil
+}
@@ -0,0 +1,108 @@
+// Code generated by sbgen -type Sample -magic smpl -prefix Sample; DO NOT EDIT.
+
+package sample
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func TestSampleSampleRoundTrip(t *testing.T) {
+	ip := func(s string) *net.IP {
+		i := net.ParseIP(s)
+		return &i
+	}
+	in := &Sample{
+		Byte:   1,
+		Uint8:  2,
+		Bytes:  []byte{3, 2, 3},
+		String: "String",
+		Int16:  -165,
+		Int32:  -326,
+		Int64:  -647,
+		Uint16: 168,
+		Uint32: 329,
+		Uint64: 6410,
+		Time:   time.Unix(1600000000, 11),
+		IP:     ip("192.0.2.12"),
+		IPs:    []*net.IP{ip("192.0.2.13"), ip("2001:db8::13")},
+		Hash:   chainhash.Hash{14, 2, 3},
+		Hashes: map[int32]*chainhash.Hash{15: {1}, 100: {2}},
+		First:  "First",
+		Second: "Second",
+	}
+	c := GetSample(in)
+	if !bytes.Equal(c.GetMagic(), SampleMagic) {
+		t.Fatal("container has the wrong magic")
+	}
+	if c.Count() != 17 {
+		t.Fatal("container has", c.Count(), "fields, expected 17")
+	}
+	loaded, err := LoadSampleContainer(c.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSampleContainer(c.Data[:len(c.Data)-1]); err == nil {
+		t.Fatal("a truncated container was loaded")
+	}
+	out, err := loaded.Struct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in.Byte, out.Byte) {
+		t.Fatal("Byte did not survive the round trip", in.Byte, out.Byte)
+	}
+	if !reflect.DeepEqual(in.Uint8, out.Uint8) {
+		t.Fatal("Uint8 did not survive the round trip", in.Uint8, out.Uint8)
+	}
+	if !bytes.Equal(in.Bytes, out.Bytes) {
+		t.Fatal("Bytes did not survive the round trip", in.Bytes, out.Bytes)
+	}
+	if !reflect.DeepEqual(in.String, out.String) {
+		t.Fatal("String did not survive the round trip", in.String, out.String)
+	}
+	if !reflect.DeepEqual(in.Int16, out.Int16) {
+		t.Fatal("Int16 did not survive the round trip", in.Int16, out.Int16)
+	}
+	if !reflect.DeepEqual(in.Int32, out.Int32) {
+		t.Fatal("Int32 did not survive the round trip", in.Int32, out.Int32)
+	}
+	if !reflect.DeepEqual(in.Int64, out.Int64) {
+		t.Fatal("Int64 did not survive the round trip", in.Int64, out.Int64)
+	}
+	if !reflect.DeepEqual(in.Uint16, out.Uint16) {
+		t.Fatal("Uint16 did not survive the round trip", in.Uint16, out.Uint16)
+	}
+	if !reflect.DeepEqual(in.Uint32, out.Uint32) {
+		t.Fatal("Uint32 did not survive the round trip", in.Uint32, out.Uint32)
+	}
+	if !reflect.DeepEqual(in.Uint64, out.Uint64) {
+		t.Fatal("Uint64 did not survive the round trip", in.Uint64, out.Uint64)
+	}
+	if !in.Time.Equal(out.Time) {
+		t.Fatal("Time did not survive the round trip", in.Time, out.Time)
+	}
+	if !reflect.DeepEqual(in.IP, out.IP) {
+		t.Fatal("IP did not survive the round trip", in.IP, out.IP)
+	}
+	if !reflect.DeepEqual(in.IPs, out.IPs) {
+		t.Fatal("IPs did not survive the round trip", in.IPs, out.IPs)
+	}
+	if !reflect.DeepEqual(in.Hash, out.Hash) {
+		t.Fatal("Hash did not survive the round trip", in.Hash, out.Hash)
+	}
+	if !reflect.DeepEqual(in.Hashes, out.Hashes) {
+		t.Fatal("Hashes did not survive the round trip", in.Hashes, out.Hashes)
+	}
+	if !reflect.DeepEqual(in.First, out.First) {
+		t.Fatal("First did not survive the round trip", in.First, out.First)
+	}
+	if !reflect.DeepEqual(in.Second, out.Second) {
+		t.Fatal("Second did not survive the round trip", in.Second, out.Second)
+	}
+}
@@ -0,0 +1,79 @@
+// Command sbgen generates simplebuffer containers for message structs, for use with go generate:
+//
+//	//go:generate go run github.com/p9c/pkg/coding/simplebuffer/cmd/sbgen -type Entry -magic entr
+//
+// It writes the magic, the container type, a constructor that serializes the struct, a loader that validates it, one
+// typed accessor per field that decodes only that field, Struct() to decode them all, and a round trip test. The
+// accessors and Struct return an error for a malformed field rather than a zero value that looks valid. Fields are
+// serialized in the order they are declared. The simplebuffer type of a field follows from its Go type, and may be
+// stated with a tag such as `sb:"String"` which is checked against it. A tag of `sb:"-"` leaves the field out.
+//
+// The names follow the single message packages like Entry: Container, Get and LoadContainer. When a package holds more
+// than one message give each a -prefix, which is put into the names, as in FooContainer, GetFoo and LoadFooContainer
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the struct to generate a container for")
+	magic := flag.String("magic", "", "4 byte magic identifying the message")
+	prefix := flag.String("prefix", "", "prefix of the generated names, for packages with more than one message")
+	output := flag.String("output", "", "file to write, default <type>_sb.go in the directory of the source")
+	flag.Parse()
+	if *typeName == "" || *magic == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if err := run(dir, *typeName, *magic, *prefix, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "sbgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, typeName, magic, prefix, output string) (err error) {
+	if output == "" {
+		output = filepath.Join(dir, strings.ToLower(typeName)+"_sb.go")
+	}
+	fset := token.NewFileSet()
+	// generated files are left out so a broken one can be regenerated
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && !strings.HasSuffix(fi.Name(), "_sb.go")
+	}, 0)
+	if err != nil {
+		return
+	}
+	var files []*ast.File
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+	}
+	m, err := findStruct(files, typeName, magic)
+	if err != nil {
+		return
+	}
+	m.Prefix = prefix
+	m.Command = "sbgen " + strings.Join(os.Args[1:], " ")
+	code, test, err := m.render()
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(output, code, 0644); err != nil {
+		return
+	}
+	return ioutil.WriteFile(strings.TrimSuffix(output, ".go")+"_test.go", test, 0644)
+}
@@ -0,0 +1,104 @@
+// Package sbtest checks that simplebuffer field types follow the Serializer contract. Each field type's tests run
+// Conformance with a few sample values, which must include the edge cases of the type such as empty values
+package sbtest
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+)
+
+// RandomInputs is the number of random slices Conformance decodes
+const RandomInputs = 10000
+
+// Conformance checks the serializers made by newS against the encodings of the samples:
+//
+// - a sample's encoding decodes with an empty remainder and encodes back to the same bytes
+//
+// - bytes after an encoding are returned as the remainder, so encodings can be concatenated
+//
+// - every truncation of an encoding returns an error wrapping simplebuffer.ErrFieldTruncated and leaves the value the
+// serializer held before unchanged
+//
+// - random input never panics, and when it decodes the remainder is the tail of the input
+func Conformance(t *testing.T, newS func() simplebuffer.Serializer, samples ...simplebuffer.Serializer) {
+	t.Helper()
+	if len(samples) == 0 {
+		t.Fatal("no samples to check")
+	}
+	var all []byte
+	for i, sample := range samples {
+		enc := sample.Encode()
+		all = append(all, enc...)
+		s := newS()
+		rest, err := decode(t, s, enc)
+		if err != nil {
+			t.Fatalf("sample %d %x: %v", i, enc, err)
+		}
+		if len(rest) != 0 {
+			t.Fatalf("sample %d %x has a remainder of %x", i, enc, rest)
+		}
+		if !bytes.Equal(s.Encode(), enc) {
+			t.Fatalf("sample %d %x encodes back as %x", i, enc, s.Encode())
+		}
+		trailer := []byte{0xde, 0xad, 0xbe, 0xef}
+		if rest, err = decode(t, newS(), append(append([]byte{}, enc...), trailer...)); err != nil {
+			t.Fatalf("sample %d %x with bytes after it: %v", i, enc, err)
+		}
+		if !bytes.Equal(rest, trailer) {
+			t.Fatalf("sample %d %x with bytes after it has the remainder %x", i, enc, rest)
+		}
+		for n := 0; n < len(enc); n++ {
+			if rest, err = decode(t, s, enc[:n]); !errors.Is(err, simplebuffer.ErrFieldTruncated) {
+				t.Fatalf("sample %d %x truncated to %d bytes: expected a truncation error, got %v", i, enc, n, err)
+			}
+			if rest != nil {
+				t.Fatalf("sample %d %x truncated to %d bytes has a remainder", i, enc, n)
+			}
+			if !bytes.Equal(s.Encode(), enc) {
+				t.Fatalf("sample %d %x truncated to %d bytes changed the value to %x", i, enc, n, s.Encode())
+			}
+		}
+	}
+	rest := all
+	for i, sample := range samples {
+		s := newS()
+		var err error
+		if rest, err = decode(t, s, rest); err != nil {
+			t.Fatalf("sample %d of the concatenated samples: %v", i, err)
+		}
+		if !bytes.Equal(s.Encode(), sample.Encode()) {
+			t.Fatalf("sample %d of the concatenated samples decoded as %x", i, s.Encode())
+		}
+	}
+	if len(rest) != 0 {
+		t.Fatalf("concatenated samples have a remainder of %x", rest)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < RandomInputs; i++ {
+		in := make([]byte, r.Intn(80))
+		r.Read(in)
+		// small counts and lengths are more likely to decode than random ones
+		if len(in) > 0 && r.Intn(2) == 0 {
+			in[0] %= 4
+		}
+		rest, err := decode(t, newS(), in)
+		if err == nil && (len(rest) > len(in) || !bytes.Equal(rest, in[len(in)-len(rest):])) {
+			t.Fatalf("random input %x has the remainder %x which is not its tail", in, rest)
+		}
+	}
+}
+
+// decode decodes b with s, failing the test if it panics
+func decode(t *testing.T, s simplebuffer.Serializer, b []byte) (rest []byte, err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic decoding %x: %v", b, r)
+		}
+	}()
+	return s.Decode(b)
+}
@@ -1,20 +1,56 @@
 package simplebuffer
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 
 	"github.com/p9c/pkg/app/slog"
 )
 
+// Serializer is a field type. Each also has a New constructor, Put and Get methods to set and read the value, and a
+// DecodeOne method for reading a field from a container in a single expression, which leaves a field that does not
+// decode at its zero value. The contract is checked by the harness in the sbtest package
 type Serializer interface {
 	// Encode returns the wire/storage form of the data
 	Encode() []byte
-	// Decode stores the decoded data from the head of the slice and returns the remainder
-	Decode(b []byte) []byte
+	// Decode stores the decoded data from the head of the slice and returns the remainder, which is empty if the slice
+	// held exactly the one value. The remainder is a subslice of b, not a copy. If the slice is too short to hold the
+	// value it returns an error wrapping ErrFieldTruncated and leaves the value it held before unchanged
+	Decode(b []byte) (rest []byte, err error)
 }
 
 type Serializers []Serializer
 
+// headerLen is the length of the magic, size and field count in front of the offsets of the fields
+const headerLen = 10
+
+// The kinds of error from reading a container or its fields. Errors are wrapped with the detail of what went wrong, use errors.Is to
+// find their kind
+var (
+	// ErrTruncated means the container is shorter than its header, its declared size or its offset table
+	ErrTruncated = errors.New("container truncated")
+	// ErrMagic means the container is not the expected kind of message
+	ErrMagic = errors.New("wrong container magic")
+	// ErrOffset means the offsets of a field are out of order or outside the container
+	ErrOffset = errors.New("field offset out of bounds")
+	// ErrIndex means a field past the last one was asked for
+	ErrIndex = errors.New("field index out of range")
+	// ErrFieldCount means the container does not have the number of fields of the message
+	ErrFieldCount = errors.New("wrong number of fields")
+	// ErrFieldTruncated means a field is shorter than the value it holds
+	ErrFieldTruncated = errors.New("field truncated")
+	// ErrFieldInvalid means a field is long enough but holds a value its type can't have
+	ErrFieldInvalid = errors.New("field invalid")
+)
+
+// FieldTruncated returns an error wrapping ErrFieldTruncated for a field of the named type that needs more bytes than
+// it was given
+func FieldTruncated(name string, need, got int) error {
+	return fmt.Errorf("%w: %s needs %d bytes, got %d", ErrFieldTruncated, name, need, got)
+}
+
 type Container struct {
 	Data []byte
 }
@@ -57,7 +93,12 @@ func (srs Serializers) CreateContainer(magic []byte) (out *Container) {
 	return
 }
 
+// Count returns the number of fields, or 0 if the container is too short to hold its header or shorter than its
+// declared size
 func (c *Container) Count() uint16 {
+	if len(c.Data) < headerLen {
+		return 0
+	}
 	size := binary.BigEndian.Uint32(c.Data[4:8])
 	// Debug("size", size)
 	if len(c.Data) >= int(size) {
@@ -68,7 +109,11 @@ func (c *Container) Count() uint16 {
 	return 0
 }
 
+// GetMagic returns the magic, or nil if the container is too short to have one
 func (c *Container) GetMagic() (out []byte) {
+	if len(c.Data) < 4 {
+		return nil
+	}
 	return c.Data[:4]
 }
 
@@ -76,29 +121,79 @@ func (c *Container) GetMagic() (out []byte) {
 // coded by the creation and identified by the magic. This is all read only and subslices so it should generate very
 // little garbage or copy operations except as required for the output (we aren't going to go unsafe here, it isn't
 // really necessary since already this library enables avoiding the decoding of values not being used from a message (or
-// not used yet)
-func (c *Container) Get(idx uint16) (out []byte) {
+// not used yet).
+//
+// An index past the last field or a field whose offsets lie outside the container returns an error rather than
+// panicking, so it is safe to use on data that has not been validated
+func (c *Container) Get(idx uint16) (out []byte, err error) {
 	length := c.Count()
-	size := len(c.Data)
-	if length > idx {
-		// Debug("length", length, "idx", idx)
-		if idx < length {
-			offset := binary.BigEndian.Uint32(c.
-				Data[10+idx*4 : 10+idx*4+4])
-			// Debug("offset", offset)
-			if idx < length-1 {
-				nextOffset := binary.BigEndian.Uint32(c.
-					Data[10+((idx+1)*4) : 10+((idx+1)*4)+4])
-				// Debug("nextOffset", nextOffset)
-				out = c.Data[offset:nextOffset]
-			} else {
-				nextOffset := len(c.Data)
-				// Debug("last nextOffset", nextOffset)
-				out = c.Data[offset:nextOffset]
-			}
+	if idx >= length {
+		return nil, fmt.Errorf("%w: field %d of %d", ErrIndex, idx, length)
+	}
+	start := headerLen + 4*int(length)
+	if len(c.Data) < start {
+		return nil, fmt.Errorf("%w: offset table of %d fields in %d bytes", ErrTruncated, length, len(c.Data))
+	}
+	offset := int(binary.BigEndian.Uint32(c.Data[headerLen+int(idx)*4:]))
+	next := len(c.Data)
+	if idx < length-1 {
+		next = int(binary.BigEndian.Uint32(c.Data[headerLen+(int(idx)+1)*4:]))
+	}
+	if offset < start || offset > next || next > len(c.Data) {
+		return nil, fmt.Errorf("%w: field %d spans %d to %d of %d bytes", ErrOffset, idx, offset, next, len(c.Data))
+	}
+	return c.Data[offset:next], nil
+}
+
+// Fields returns every field of the container, or an error from the first that can't be read
+func (c *Container) Fields() (out [][]byte, err error) {
+	out = make([][]byte, c.Count())
+	for i := range out {
+		if out[i], err = c.Get(uint16(i)); err != nil {
+			return nil, err
 		}
-	} else {
-		slog.Error("size mismatch", length, size)
+	}
+	return
+}
+
+// Validate checks that the container is complete and well formed, so that every field can be read with Get. The
+// declared size must be the length of the data, the offset table must fit and the offsets must be in order and within
+// the data. If a magic is given the container must have it
+func (c *Container) Validate(magic []byte) (err error) {
+	if len(c.Data) < headerLen {
+		return fmt.Errorf("%w: %d bytes is shorter than the header", ErrTruncated, len(c.Data))
+	}
+	if magic != nil && !bytes.Equal(c.Data[:4], magic) {
+		return fmt.Errorf("%w: %q, expected %q", ErrMagic, c.Data[:4], magic)
+	}
+	if size := binary.BigEndian.Uint32(c.Data[4:8]); int(size) != len(c.Data) {
+		return fmt.Errorf("%w: declared size %d of %d bytes", ErrTruncated, size, len(c.Data))
+	}
+	count := int(binary.BigEndian.Uint16(c.Data[8:10]))
+	prev := headerLen + 4*count
+	if len(c.Data) < prev {
+		return fmt.Errorf("%w: offset table of %d fields in %d bytes", ErrTruncated, count, len(c.Data))
+	}
+	for i := 0; i < count; i++ {
+		offset := int(binary.BigEndian.Uint32(c.Data[headerLen+i*4:]))
+		if offset < prev || offset > len(c.Data) {
+			return fmt.Errorf("%w: field %d at %d of %d bytes", ErrOffset, i, offset, len(c.Data))
+		}
+		prev = offset
+	}
+	return
+}
+
+// Load returns the data as a container after validating it, checking it has the magic and, if fields is not
+// negative, that number of fields. Received messages should be loaded this way so that malformed ones are rejected
+// before any field is read
+func Load(b, magic []byte, fields int) (c *Container, err error) {
+	c = &Container{Data: b}
+	if err = c.Validate(magic); err != nil {
+		return nil, err
+	}
+	if fields >= 0 && int(c.Count()) != fields {
+		return nil, fmt.Errorf("%w: %d fields, expected %d", ErrFieldCount, c.Count(), fields)
 	}
 	return
 }
@@ -1 +1,151 @@
 package simplebuffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// raw is a field that is its own encoding
+type raw []byte
+
+func (r raw) Encode() []byte { return r }
+
+func (r raw) Decode(b []byte) ([]byte, error) { return nil, nil }
+
+var testMagic = []byte("test")
+
+func testContainer(fields ...string) *Container {
+	s := Serializers{}
+	for _, f := range fields {
+		s = append(s, raw(f))
+	}
+	return s.CreateContainer(testMagic)
+}
+
+func TestContainer(t *testing.T) {
+	fields := []string{"one", "", "three"}
+	c, err := Load(testContainer(fields...).Data, testMagic, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range fields {
+		b, err := c.Get(uint16(i))
+		if err != nil || string(b) != f {
+			t.Fatal("field", i, "is", b, err)
+		}
+	}
+	all, err := c.Fields()
+	if err != nil || len(all) != 3 || string(all[2]) != "three" {
+		t.Fatal("unexpected fields", all, err)
+	}
+	if _, err = c.Get(3); !errors.Is(err, ErrIndex) {
+		t.Fatal("expected ErrIndex, got", err)
+	}
+	if _, err = Load(c.Data, nil, -1); err != nil {
+		t.Fatal("a container of any kind and number of fields did not load", err)
+	}
+	if _, err = Load(testContainer().Data, testMagic, 0); err != nil {
+		t.Fatal("an empty container did not load", err)
+	}
+}
+
+func TestContainerErrors(t *testing.T) {
+	valid := testContainer("one", "two").Data
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, valid...))
+	}
+	for name, tc := range map[string]struct {
+		b   []byte
+		err error
+	}{
+		"empty":        {nil, ErrTruncated},
+		"header":       {valid[:9], ErrTruncated},
+		"short":        {valid[:len(valid)-1], ErrTruncated},
+		"long":         {append(append([]byte{}, valid...), 0), ErrTruncated},
+		"magic":        {corrupt(func(b []byte) []byte { b[0] = 'x'; return b }), ErrMagic},
+		"offset table": {corrupt(func(b []byte) []byte { b[9] = 200; return b }), ErrTruncated},
+		"before table": {corrupt(func(b []byte) []byte { binary.BigEndian.PutUint32(b[10:], 0); return b }), ErrOffset},
+		"past end": {corrupt(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[14:], uint32(len(b)+1))
+			return b
+		}), ErrOffset},
+		"out of order": {corrupt(func(b []byte) []byte {
+			first := binary.BigEndian.Uint32(b[10:])
+			binary.BigEndian.PutUint32(b[10:], first+2)
+			binary.BigEndian.PutUint32(b[14:], first+1)
+			return b
+		}), ErrOffset},
+		"fields": {testContainer("one").Data, ErrFieldCount},
+	} {
+		if _, err := Load(tc.b, testMagic, 2); !errors.Is(err, tc.err) {
+			t.Fatal(name, "expected", tc.err, "got", err)
+		}
+	}
+	// Get on a container that was never validated reports the bad field rather than panicking
+	c := &Container{Data: corrupt(func(b []byte) []byte {
+		binary.BigEndian.PutUint32(b[14:], uint32(len(b)+1))
+		return b
+	})}
+	if _, err := c.Get(1); !errors.Is(err, ErrOffset) {
+		t.Fatal("expected ErrOffset, got", err)
+	}
+	if _, err := (&Container{Data: valid[:3]}).Get(0); !errors.Is(err, ErrIndex) {
+		t.Fatal("expected ErrIndex, got", err)
+	}
+	if (&Container{}).GetMagic() != nil || (&Container{}).Count() != 0 {
+		t.Fatal("an empty container has a magic or fields")
+	}
+}
+
+// readAll reads every field of the container the way a receiver would, which must never panic
+func readAll(t *testing.T, b []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic reading %x: %v", b, r)
+		}
+	}()
+	c := &Container{Data: b}
+	valid := c.Validate(nil) == nil
+	c.GetMagic()
+	count := int(c.Count())
+	// random headers can claim thousands of fields, the first few are enough to probe the offset table
+	n := count
+	if !valid && n > 16 {
+		n = 16
+	}
+	for i := 0; i <= n+1; i++ {
+		if _, err := c.Get(uint16(i)); err != nil && valid && i < count {
+			t.Fatalf("field %d of validated container %x: %v", i, b, err)
+		}
+	}
+}
+
+// TestContainerRandom reads random data and randomly corrupted containers
+func TestContainerRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	valid := testContainer("a", "bb", "", "dddd").Data
+	for i := 0; i < 100000; i++ {
+		var b []byte
+		switch r.Intn(4) {
+		case 0:
+			b = make([]byte, r.Intn(40))
+			r.Read(b)
+		case 1:
+			b = append([]byte{}, valid...)
+			for n := r.Intn(3) + 1; n > 0; n-- {
+				b[r.Intn(len(b))] = byte(r.Intn(256))
+			}
+		case 2:
+			b = valid[:r.Intn(len(valid))]
+		case 3:
+			// a header that is consistent with the length of random contents
+			b = make([]byte, 10+r.Intn(40))
+			r.Read(b[10:])
+			binary.BigEndian.PutUint32(b[4:], uint32(len(b)))
+			binary.BigEndian.PutUint16(b[8:], uint16(r.Intn(10)))
+		}
+		readAll(t, b)
+	}
+}
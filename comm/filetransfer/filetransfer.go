@@ -0,0 +1,558 @@
+// Package filetransfer distributes files to all of the nodes listening on a transport broadcast channel.
+//
+// A sender announces a file with its SHA-256 hash, size and name, then streams it in segments each of which is one fec
+// segment on the wire, so the loss of some of its packets is repaired by the parity shards. Receivers write segments to
+// a partial file as they arrive, and when a transfer goes quiet, request the segments they are still missing from any
+// node that has the whole file. A completed file is only moved into place once its hash matches the announcement.
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/fec"
+	"github.com/p9c/pkg/comm/transport"
+)
+
+const (
+	// SegmentSize is the size of the pieces files are sent in, which leaves room in one fec segment for the header of
+	// the segment message
+	SegmentSize = 15 * fec.ShardSize
+	// DefaultPort is the port of the broadcast channel file transfers run on
+	DefaultPort = 11050
+	// DefaultMaxDatagramSize also sizes the socket receive buffer of the channel, which must hold bursts of the packets
+	// of several segments
+	DefaultMaxDatagramSize = 1 << 20
+	// DefaultRedundancy is the percentage of fec parity shards added to each segment
+	DefaultRedundancy = 50
+	// DefaultInterval is the gap left between sending segments so a receiver's socket buffer is not overrun
+	DefaultInterval = time.Millisecond * 2
+	// DefaultRequestInterval is how long a transfer can go without receiving a segment before missing segments are
+	// requested
+	DefaultRequestInterval = time.Millisecond * 500
+	// DefaultMaxRequest is the largest number of segments requested at once
+	DefaultMaxRequest = 256
+	// DefaultMaxStalls is the number of requests in a row that can go unanswered before a transfer is abandoned
+	DefaultMaxStalls = 20
+	// DefaultMaxSize is the largest file accepted by default. Anyone holding the key can announce a file, and the
+	// receiver sets aside a partial file of the announced size
+	DefaultMaxSize = 1 << 30
+)
+
+var (
+	// ErrVerify is returned for a file that does not match the hash it was announced with
+	ErrVerify = errors.New("file does not match its announced hash")
+	// ErrStalled is returned for a transfer abandoned because its missing segments were not sent
+	ErrStalled = errors.New("transfer stalled")
+	// ErrExists is returned for a received file that could not be moved into place without replacing another file
+	ErrExists = errors.New("a file of that name already exists")
+)
+
+// Config configures a file transfer Service. Zero values are replaced with the defaults
+type Config struct {
+	// Creator is the sender ID sealed in to the messages sent
+	Creator string
+	// Key is the pre shared key of the channel
+	Key string
+	// Port is the port of the broadcast channel
+	Port int
+	// MaxDatagramSize is the size of the receive buffer of the channel, and of its socket
+	MaxDatagramSize int
+	// Dir is where received files are written
+	Dir string
+	// Redundancy is the percentage of fec parity shards added to each segment
+	Redundancy int
+	// Interval is the gap between sending segments
+	Interval time.Duration
+	// RequestInterval is how long a transfer can be quiet before missing segments are requested
+	RequestInterval time.Duration
+	// MaxRequest is the most segments requested at once
+	MaxRequest int
+	// MaxStalls is how many requests in a row can go unanswered before a transfer is abandoned
+	MaxStalls int
+	// MaxSize is the largest file that is accepted
+	MaxSize uint64
+	// Accept decides whether to receive an announced file, if it is nil all files are received
+	Accept func(a *Announce) bool
+	// OnComplete is called when a transfer finishes, with the path of the file or the error that ended it. It runs on a
+	// goroutine of its own without the Service locked, so it may block or call the Service
+	OnComplete func(a *Announce, path string, err error)
+}
+
+// Service sends and receives files on a broadcast channel
+type Service struct {
+	cfg       Config
+	channel   *transport.Channel
+	quit      chan struct{}
+	mx        sync.Mutex
+	served    map[ID]string
+	serving   map[ID]bool
+	transfers map[ID]*transfer
+	// closed is set once quit is closed, after which no transfer is started or ended and segments are dropped
+	closed bool
+	// drop discards received segments for which it returns true, to simulate loss
+	drop func(index uint32) bool
+}
+
+// bitmap is a set of segment indices, a bit for each
+type bitmap []byte
+
+func newBitmap(n uint32) bitmap {
+	return make(bitmap, (uint64(n)+7)/8)
+}
+
+func (b bitmap) has(i uint32) bool {
+	return b[i/8]&(1<<(i%8)) != 0
+}
+
+func (b bitmap) set(i uint32) {
+	b[i/8] |= 1 << (i % 8)
+}
+
+// transfer is a file being received. The partial file holds the data of the file followed by the bitmap of the
+// segments written, so a transfer that is announced again after being interrupted resumes with the segments already
+// received
+type transfer struct {
+	*Announce
+	file     *os.File
+	part     string
+	received bitmap
+	missing  uint32
+	last     time.Time
+	stalls   int
+	// done is set once every segment is received, while the file is verified and moved into place
+	done bool
+}
+
+// open opens the partial file of the transfer and reads which segments it already holds, or starts it afresh if it is
+// not a partial file of this transfer
+func (t *transfer) open() (err error) {
+	if t.file, err = os.OpenFile(t.part, os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		return
+	}
+	segments := t.Segments()
+	t.received = newBitmap(segments)
+	t.missing = segments
+	var fi os.FileInfo
+	if fi, err = t.file.Stat(); err != nil {
+		return
+	}
+	if uint64(fi.Size()) == t.Size+uint64(len(t.received)) {
+		if _, err = t.file.ReadAt(t.received, int64(t.Size)); err != nil {
+			return
+		}
+		for i := uint32(0); i < segments; i++ {
+			if t.received.has(i) {
+				t.missing--
+			}
+		}
+		return
+	}
+	if err = t.file.Truncate(0); err != nil {
+		return
+	}
+	return t.file.Truncate(int64(t.Size) + int64(len(t.received)))
+}
+
+// mark records a written segment in the bitmap and in the partial file. It is called with the mutex held, so the byte
+// of the bitmap it writes is not changed meanwhile
+func (t *transfer) mark(index uint32) (err error) {
+	t.received.set(index)
+	_, err = t.file.WriteAt(t.received[index/8:index/8+1], int64(t.Size)+int64(index/8))
+	return
+}
+
+// New starts a file transfer Service on a broadcast channel, which runs until quit is closed
+func New(cfg Config, quit chan struct{}) (s *Service, err error) {
+	if cfg.Port == 0 {
+		cfg.Port = DefaultPort
+	}
+	if cfg.MaxDatagramSize <= 0 {
+		cfg.MaxDatagramSize = DefaultMaxDatagramSize
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.Redundancy <= 0 {
+		cfg.Redundancy = DefaultRedundancy
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.RequestInterval <= 0 {
+		cfg.RequestInterval = DefaultRequestInterval
+	}
+	if cfg.MaxRequest <= 0 {
+		cfg.MaxRequest = DefaultMaxRequest
+	}
+	if cfg.MaxStalls <= 0 {
+		cfg.MaxStalls = DefaultMaxStalls
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	s = &Service{
+		cfg:       cfg,
+		quit:      quit,
+		served:    make(map[ID]string),
+		serving:   make(map[ID]bool),
+		transfers: make(map[ID]*transfer),
+	}
+	handlers := transport.Handlers{
+		string(AnnounceMagic): s.handleAnnounce,
+		string(SegmentMagic):  s.handleSegment,
+		string(RequestMagic):  s.handleRequest,
+	}
+	if s.channel, err = transport.NewBroadcastChannel(cfg.Creator, s, cfg.Key, cfg.Port, cfg.MaxDatagramSize,
+		handlers, quit); slog.Check(err) {
+		return nil, err
+	}
+	s.channel.SetCodec(fec.NewCodec("filetransfer", cfg.Redundancy))
+	go s.requestLoop()
+	return
+}
+
+// Send announces a file and streams it to the channel, after which the Service answers requests for its segments
+func (s *Service) Send(path string) (a *Announce, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer func() {
+		if err := f.Close(); slog.Check(err) {
+		}
+	}()
+	h := sha256.New()
+	var size int64
+	if size, err = io.Copy(h, f); err != nil {
+		return
+	}
+	a = &Announce{Size: uint64(size), Name: filepath.Base(path)}
+	copy(a.ID[:], h.Sum(nil))
+	s.mx.Lock()
+	s.served[a.ID] = path
+	s.mx.Unlock()
+	if err = s.channel.SendMessage(AnnounceMagic, a.encode()); slog.Check(err) {
+		return
+	}
+	all := make([]uint32, a.Segments())
+	for i := range all {
+		all[i] = uint32(i)
+	}
+	if err = s.sendSegments(f, a, all); err != nil {
+		return
+	}
+	// announce again for receivers that started listening after the first announcement, so they can request the file
+	err = s.channel.SendMessage(AnnounceMagic, a.encode())
+	return
+}
+
+// sendSegments sends the segments with the given indices from f, pausing for the configured interval between each
+func (s *Service) sendSegments(f *os.File, a *Announce, indices []uint32) (err error) {
+	buf := make([]byte, SegmentSize)
+	for _, index := range indices {
+		if index >= a.Segments() {
+			continue
+		}
+		data := buf[:a.segmentLen(index)]
+		if _, err = f.ReadAt(data, int64(index)*SegmentSize); err != nil {
+			return
+		}
+		seg := &segment{id: a.ID, index: index, data: data}
+		if err = s.channel.SendMessage(SegmentMagic, seg.encode()); slog.Check(err) {
+			return
+		}
+		select {
+		case <-time.After(s.cfg.Interval):
+		case <-s.quit:
+			return
+		}
+	}
+	return
+}
+
+func (s *Service) handleAnnounce(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	var a *Announce
+	if a, err = decodeAnnounce(b); err != nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		return
+	}
+	if _, ok := s.served[a.ID]; ok {
+		return
+	}
+	if _, ok := s.transfers[a.ID]; ok {
+		return
+	}
+	name := filepath.Base(a.Name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("announced file name %q is not usable", a.Name)
+	}
+	a.Name = name
+	if a.Size > s.cfg.MaxSize {
+		slog.Debug("ignoring announced file", a.Name, "of", a.Size, "bytes, larger than", s.cfg.MaxSize)
+		return
+	}
+	if a.Size/SegmentSize >= 1<<32 {
+		return fmt.Errorf("announced file %q of %d bytes has too many segments", a.Name, a.Size)
+	}
+	if s.cfg.Accept != nil && !s.cfg.Accept(a) {
+		return
+	}
+	t := &transfer{
+		Announce: a,
+		part:     filepath.Join(s.cfg.Dir, fmt.Sprintf(".%s.%s.part", a.Name, a.ID.String()[:16])),
+		last:     time.Now(),
+	}
+	if err = t.open(); slog.Check(err) {
+		s.abandon(t, err)
+		return
+	}
+	slog.Debug("receiving", a.Name, a.Size, "bytes in", a.Segments(), "segments,", t.missing, "missing")
+	s.transfers[a.ID] = t
+	if t.missing == 0 {
+		t.done = true
+		go s.complete(t)
+	}
+	return
+}
+
+func (s *Service) handleSegment(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	var seg *segment
+	if seg, err = decodeSegment(b); err != nil {
+		return
+	}
+	s.mx.Lock()
+	t, ok := s.transfers[seg.id]
+	if !ok || seg.index >= t.Segments() || t.received.has(seg.index) || (s.drop != nil && s.drop(seg.index)) {
+		s.mx.Unlock()
+		return
+	}
+	s.mx.Unlock()
+	if len(seg.data) != t.segmentLen(seg.index) {
+		return fmt.Errorf("segment %d of %s is %d bytes, expected %d", seg.index, t.Name, len(seg.data),
+			t.segmentLen(seg.index))
+	}
+	// the data is written without the lock held, a transfer ended meanwhile has its file closed so this fails, and the
+	// failure is dropped below as the transfer is no longer in the table
+	_, err = t.file.WriteAt(seg.data, int64(seg.index)*SegmentSize)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.transfers[seg.id] != t || t.received.has(seg.index) {
+		return nil
+	}
+	if err == nil {
+		err = t.mark(seg.index)
+	}
+	if slog.Check(err) {
+		s.abandon(t, err)
+		return
+	}
+	t.missing--
+	t.last = time.Now()
+	t.stalls = 0
+	if t.missing == 0 {
+		t.done = true
+		go s.complete(t)
+	}
+	return
+}
+
+func (s *Service) handleRequest(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	var r *request
+	if r, err = decodeRequest(b); err != nil {
+		return
+	}
+	s.mx.Lock()
+	path, ok := s.served[r.id]
+	if !ok || s.serving[r.id] {
+		// requests that arrive while the file is being sent again will be repeated if still needed
+		s.mx.Unlock()
+		return
+	}
+	s.serving[r.id] = true
+	s.mx.Unlock()
+	// sending is slow and must not hold up the channel's receive loop
+	go func() {
+		defer func() {
+			s.mx.Lock()
+			delete(s.serving, r.id)
+			s.mx.Unlock()
+		}()
+		var f *os.File
+		var err error
+		if f, err = os.Open(path); slog.Check(err) {
+			return
+		}
+		defer func() {
+			if err := f.Close(); slog.Check(err) {
+			}
+		}()
+		var fi os.FileInfo
+		if fi, err = f.Stat(); slog.Check(err) {
+			return
+		}
+		if len(r.missing) > s.cfg.MaxRequest {
+			r.missing = r.missing[:s.cfg.MaxRequest]
+		}
+		a := &Announce{ID: r.id, Size: uint64(fi.Size())}
+		if err = s.sendSegments(f, a, r.missing); slog.Check(err) {
+		}
+	}()
+	return
+}
+
+// requestLoop requests the missing segments of transfers that have gone quiet
+func (s *Service) requestLoop() {
+	ticker := time.NewTicker(s.cfg.RequestInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			// the transfers are dropped from the table before their files are closed, so segments being written are
+			// discarded rather than ending their transfers, and the partial files are kept to resume from. Transfers
+			// being completed close their own files
+			s.mx.Lock()
+			s.closed = true
+			for id, t := range s.transfers {
+				delete(s.transfers, id)
+				if t.done {
+					continue
+				}
+				if err := t.file.Close(); slog.Check(err) {
+				}
+			}
+			s.mx.Unlock()
+			return
+		}
+		var requests []*request
+		now := time.Now()
+		s.mx.Lock()
+		for _, t := range s.transfers {
+			if t.done || now.Sub(t.last) < s.cfg.RequestInterval {
+				continue
+			}
+			if t.stalls >= s.cfg.MaxStalls {
+				s.abandon(t, ErrStalled)
+				continue
+			}
+			t.stalls++
+			t.last = now
+			r := &request{id: t.ID}
+			for i := uint32(0); i < t.Segments(); i++ {
+				if !t.received.has(i) {
+					r.missing = append(r.missing, i)
+					if len(r.missing) >= s.cfg.MaxRequest {
+						break
+					}
+				}
+			}
+			requests = append(requests, r)
+		}
+		s.mx.Unlock()
+		for _, r := range requests {
+			slog.Debug("requesting", len(r.missing), "missing segments of", r.id)
+			if err := s.channel.SendMessage(RequestMagic, r.encode()); slog.Check(err) {
+			}
+		}
+	}
+}
+
+// complete verifies a fully received file and moves it into place. It runs on its own goroutine without the mutex held,
+// the transfer stays in the table until it is done so that segments and announcements arriving meanwhile are ignored
+func (s *Service) complete(t *transfer) {
+	path, err := s.verify(t)
+	s.mx.Lock()
+	delete(s.transfers, t.ID)
+	if err == nil {
+		s.served[t.ID] = path
+	}
+	s.mx.Unlock()
+	if err != nil {
+		s.discard(t, err)
+		return
+	}
+	slog.Debug("received", t.Name, "to", path)
+	if s.cfg.OnComplete != nil {
+		s.cfg.OnComplete(t.Announce, path, nil)
+	}
+}
+
+// verify checks the hash of a fully received file and moves it into place without replacing an existing file. If a file
+// of the announced name exists the file is saved under the name with the start of its ID appended
+func (s *Service) verify(t *transfer) (path string, err error) {
+	if err = t.file.Truncate(int64(t.Size)); slog.Check(err) {
+		return
+	}
+	if _, err = t.file.Seek(0, io.SeekStart); slog.Check(err) {
+		return
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, t.file); slog.Check(err) {
+		return
+	}
+	var id ID
+	copy(id[:], h.Sum(nil))
+	if id != t.ID {
+		return "", ErrVerify
+	}
+	if err = t.file.Close(); slog.Check(err) {
+		return
+	}
+	for _, name := range []string{t.Name, fmt.Sprintf("%s.%s", t.Name, t.ID.String()[:16])} {
+		path = filepath.Join(s.cfg.Dir, name)
+		// a hard link fails rather than replacing an existing file, unlike a rename
+		if err = os.Link(t.part, path); err == nil {
+			if err = os.Remove(t.part); slog.Check(err) {
+			}
+			return path, nil
+		}
+		if !os.IsExist(err) {
+			slog.Check(err)
+			return
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrExists, t.Name)
+}
+
+// abandon ends a transfer, removing its partial file on another goroutine. It is called with the mutex held
+func (s *Service) abandon(t *transfer, reason error) {
+	delete(s.transfers, t.ID)
+	go s.discard(t, reason)
+}
+
+// discard closes and removes the partial file of an ended transfer and reports why it ended. Once the Service is closed
+// the partial file is kept, as the transfer may have ended only because its file was closed
+func (s *Service) discard(t *transfer, reason error) {
+	if t.file != nil {
+		if err := t.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			slog.Debug(err)
+		}
+	}
+	s.mx.Lock()
+	closed := s.closed
+	s.mx.Unlock()
+	if closed {
+		slog.Debug("keeping the partial file of", t.Name, "to resume from")
+		return
+	}
+	if err := os.Remove(t.part); err != nil && !os.IsNotExist(err) {
+		slog.Debug(err)
+	}
+	slog.Warn("abandoned transfer of", t.Name, reason)
+	if s.cfg.OnComplete != nil {
+		s.cfg.OnComplete(t.Announce, "", reason)
+	}
+}
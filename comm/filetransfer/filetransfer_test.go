@@ -0,0 +1,297 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Byte"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/String"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint64"
+)
+
+type result struct {
+	a    *Announce
+	path string
+	err  error
+}
+
+// newPair starts a sender and a receiver, if reseed is set the receiver sends each file it receives on from its
+// OnComplete handler
+func newPair(t *testing.T, port int, quit chan struct{}, reseed bool) (sender, receiver *Service, dir string,
+	results chan result) {
+	var err error
+	if dir, err = ioutil.TempDir("", "filetransfer"); err != nil {
+		t.Fatal(err)
+	}
+	results = make(chan result, 4)
+	cfg := Config{Creator: "receiver", Key: "file transfer test", Port: port, Dir: filepath.Join(dir, "in"),
+		RequestInterval: time.Millisecond * 100,
+		OnComplete: func(a *Announce, path string, err error) {
+			if reseed && err == nil {
+				_, err = receiver.Send(path)
+			}
+			results <- result{a, path, err}
+		},
+	}
+	if err = os.Mkdir(cfg.Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if receiver, err = New(cfg, quit); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Creator, cfg.Dir, cfg.OnComplete = "sender", dir, nil
+	if sender, err = New(cfg, quit); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func writeFile(t *testing.T, dir string, size int) (path string, data []byte) {
+	data = make([]byte, size)
+	_, _ = rand.Read(data)
+	path = filepath.Join(dir, "payload.bin")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func checkResult(t *testing.T, results chan result, data []byte) {
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		got, err := ioutil.ReadFile(r.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) || r.a.Name != "payload.bin" {
+			t.Fatal("received file does not match the one sent")
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("file was not received")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	sender, _, dir, results := newPair(t, 11874, quit, false)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path, data := writeFile(t, dir, SegmentSize*5+123)
+	a, err := sender.Send(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Segments() != 6 || a.Size != uint64(len(data)) {
+		t.Fatal("announcement does not describe the file:", a.Segments(), a.Size)
+	}
+	checkResult(t, results, data)
+}
+
+func TestTransferResume(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	sender, receiver, dir, results := newPair(t, 11875, quit, false)
+	defer func() { _ = os.RemoveAll(dir) }()
+	// every other segment is lost the first time it is sent
+	dropped := make(map[uint32]bool)
+	receiver.mx.Lock()
+	receiver.drop = func(index uint32) bool {
+		if index%2 == 0 && !dropped[index] {
+			dropped[index] = true
+			return true
+		}
+		return false
+	}
+	receiver.mx.Unlock()
+	path, data := writeFile(t, dir, SegmentSize*8)
+	if _, err := sender.Send(path); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, results, data)
+	receiver.mx.Lock()
+	defer receiver.mx.Unlock()
+	if len(dropped) != 4 {
+		t.Fatal("expected 4 segments to have been dropped and requested again, got", len(dropped))
+	}
+}
+
+func TestTransferExisting(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	sender, _, dir, results := newPair(t, 11881, quit, true)
+	defer func() { _ = os.RemoveAll(dir) }()
+	existing := filepath.Join(dir, "in", "payload.bin")
+	if err := ioutil.WriteFile(existing, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path, data := writeFile(t, dir, SegmentSize*2)
+	a, err := sender.Send(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, results, data)
+	if got, err := ioutil.ReadFile(existing); err != nil || string(got) != "keep me" {
+		t.Fatal("an existing file was replaced", err)
+	}
+	if _, err = os.Stat(existing + "." + a.ID.String()[:16]); err != nil {
+		t.Fatal("the received file was not saved under a name with its ID", err)
+	}
+}
+
+func TestPartialFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	a := &Announce{Size: SegmentSize*3 + 5, Name: "partial"}
+	part := filepath.Join(dir, "partial.part")
+	// a stale file of another size is started afresh
+	if err = ioutil.WriteFile(part, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	first := &transfer{Announce: a, part: part}
+	if err = first.open(); err != nil {
+		t.Fatal(err)
+	}
+	if first.missing != 4 {
+		t.Fatal("expected 4 missing segments, got", first.missing)
+	}
+	if err = first.mark(2); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// reopened, the segments marked as written are not requested again
+	second := &transfer{Announce: a, part: part}
+	if err = second.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.file.Close() }()
+	if second.missing != 3 || !second.received.has(2) || second.received.has(0) {
+		t.Fatal("received segments were not read back from the partial file", second.received)
+	}
+}
+
+// newReceiver returns a Service without a channel, for calling its handlers directly
+func newReceiver(t *testing.T, quit chan struct{}) (s *Service, dir string) {
+	var err error
+	if dir, err = ioutil.TempDir("", "filetransfer"); err != nil {
+		t.Fatal(err)
+	}
+	s = &Service{
+		cfg: Config{Dir: dir, MaxSize: DefaultMaxSize, RequestInterval: time.Hour, MaxRequest: DefaultMaxRequest,
+			MaxStalls: DefaultMaxStalls},
+		quit:      quit,
+		served:    make(map[ID]string),
+		serving:   make(map[ID]bool),
+		transfers: make(map[ID]*transfer),
+	}
+	return
+}
+
+func TestAnnounceTooLarge(t *testing.T) {
+	s, dir := newReceiver(t, make(chan struct{}))
+	defer func() { _ = os.RemoveAll(dir) }()
+	a := &Announce{Size: DefaultMaxSize + 1, Name: "large"}
+	if err := s.handleAnnounce(nil, nil, "", a.encode()); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.transfers) != 0 || len(files) != 0 {
+		t.Fatal("a file larger than the limit was set up to be received")
+	}
+}
+
+func TestQuitKeepsPartialFile(t *testing.T) {
+	quit := make(chan struct{})
+	s, dir := newReceiver(t, quit)
+	defer func() { _ = os.RemoveAll(dir) }()
+	a := &Announce{Size: SegmentSize * 2, Name: "resume"}
+	if err := s.handleAnnounce(nil, nil, "", a.encode()); err != nil {
+		t.Fatal(err)
+	}
+	tr := s.transfers[a.ID]
+	if tr == nil {
+		t.Fatal("transfer was not started")
+	}
+	done := make(chan struct{})
+	go func() {
+		s.requestLoop()
+		close(done)
+	}()
+	close(quit)
+	<-done
+	// a segment arriving after the files are closed is dropped, and a transfer ending now keeps its partial file
+	seg := &segment{id: a.ID, index: 0, data: make([]byte, SegmentSize)}
+	if err := s.handleSegment(nil, nil, "", seg.encode()); err != nil {
+		t.Fatal("a late segment was not dropped", err)
+	}
+	s.discard(tr, ErrStalled)
+	if _, err := os.Stat(tr.part); err != nil {
+		t.Fatal("the partial file was removed on shutdown", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	a := &Announce{Size: 12345, Name: "name"}
+	a.ID[0], a.ID[31] = 1, 2
+	got, err := decodeAnnounce(a.encode())
+	if err != nil || *got != *a {
+		t.Fatal("announcement did not survive the round trip", got, err)
+	}
+	r := &request{id: a.ID, missing: []uint32{0, 7, 1 << 31}}
+	gotR, err := decodeRequest(r.encode())
+	if err != nil || gotR.id != r.id || len(gotR.missing) != 3 || gotR.missing[2] != 1<<31 {
+		t.Fatal("request did not survive the round trip", gotR, err)
+	}
+	b := a.encode()
+	// a field offset pointing past the end of the message
+	b[10], b[11] = 0xff, 0xff
+	if _, err = decodeAnnounce(b); err == nil {
+		t.Fatal("expected an error for a corrupt message")
+	}
+	if _, err = decodeSegment(b[:12]); err == nil {
+		t.Fatal("expected an error for a truncated message")
+	}
+	// well formed containers holding fields too short for their types
+	short := func(magic []byte, s ...simplebuffer.Serializer) []byte {
+		return simplebuffer.Serializers(s).CreateContainer(magic).Data
+	}
+	for name, err := range map[string]error{
+		"ID": func() (err error) {
+			_, err = decodeAnnounce(short(AnnounceMagic, Byte.New().Put(1), Uint64.New().Put(1), String.New().Put("x")))
+			return
+		}(),
+		"index": func() (err error) {
+			_, err = decodeSegment(short(SegmentMagic, hashField(a.ID), Byte.New().Put(1), Bytes.New().Put(nil)))
+			return
+		}(),
+		"segment list": func() (err error) {
+			_, err = decodeRequest(short(RequestMagic, hashField(a.ID), Byte.New().Put(1)))
+			return
+		}(),
+	} {
+		if !errors.Is(err, simplebuffer.ErrFieldTruncated) {
+			t.Fatal("expected a truncated", name, "got", err)
+		}
+	}
+	if ErrVerify == nil || errors.Is(ErrVerify, ErrStalled) {
+		t.Fatal("errors are not distinct")
+	}
+}
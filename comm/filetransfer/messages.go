@@ -0,0 +1,165 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/Hash"
+	"github.com/p9c/pkg/coding/simplebuffer/String"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint32"
+	"github.com/p9c/pkg/coding/simplebuffer/Uint64"
+)
+
+var (
+	// AnnounceMagic is the magic of the message offering a file
+	AnnounceMagic = []byte("fann")
+	// SegmentMagic is the magic of the message carrying a segment of a file
+	SegmentMagic = []byte("fseg")
+	// RequestMagic is the magic of the message asking for the segments of a file a receiver is missing
+	RequestMagic = []byte("freq")
+)
+
+// ID is the SHA-256 hash of a file's contents, which identifies it in a transfer
+type ID [sha256.Size]byte
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Announce offers a file to the receivers on the channel
+type Announce struct {
+	ID   ID
+	Size uint64
+	// Name is the base name the file is saved under
+	Name string
+}
+
+// Segments returns the number of segments the file is sent in
+func (a *Announce) Segments() uint32 {
+	return uint32((a.Size + SegmentSize - 1) / SegmentSize)
+}
+
+// segmentLen returns the length of the segment with the given index, only the last can be short
+func (a *Announce) segmentLen(index uint32) int {
+	if rem := a.Size - uint64(index)*SegmentSize; rem < SegmentSize {
+		return int(rem)
+	}
+	return SegmentSize
+}
+
+func (a *Announce) encode() []byte {
+	return simplebuffer.Serializers{
+		hashField(a.ID),
+		Uint64.New().Put(a.Size),
+		String.New().Put(a.Name),
+	}.CreateContainer(AnnounceMagic).Data
+}
+
+func decodeAnnounce(b []byte) (a *Announce, err error) {
+	var f [][]byte
+	if f, err = load(b, AnnounceMagic, 3); err != nil {
+		return
+	}
+	id, size, name := Hash.New(), Uint64.New(), String.New()
+	if err = decodeFields(f, id, size, name); err != nil {
+		return
+	}
+	a = &Announce{ID: ID(*id.Get()), Size: size.Get(), Name: name.Get()}
+	return
+}
+
+// segment is a piece of a file
+type segment struct {
+	id    ID
+	index uint32
+	data  []byte
+}
+
+func (s *segment) encode() []byte {
+	return simplebuffer.Serializers{
+		hashField(s.id),
+		Uint32.New().Put(s.index),
+		Bytes.New().Put(s.data),
+	}.CreateContainer(SegmentMagic).Data
+}
+
+func decodeSegment(b []byte) (s *segment, err error) {
+	var f [][]byte
+	if f, err = load(b, SegmentMagic, 3); err != nil {
+		return
+	}
+	id, index, data := Hash.New(), Uint32.New(), Bytes.New()
+	if err = decodeFields(f, id, index, data); err != nil {
+		return
+	}
+	s = &segment{id: ID(*id.Get()), index: index.Get(), data: data.Get()}
+	return
+}
+
+// request asks for the listed segments of a file to be sent again
+type request struct {
+	id      ID
+	missing []uint32
+}
+
+func (r *request) encode() []byte {
+	packed := make([]byte, 4*len(r.missing))
+	for i := range r.missing {
+		binary.BigEndian.PutUint32(packed[i*4:], r.missing[i])
+	}
+	return simplebuffer.Serializers{
+		hashField(r.id),
+		Bytes.New().Put(packed),
+	}.CreateContainer(RequestMagic).Data
+}
+
+func decodeRequest(b []byte) (r *request, err error) {
+	var f [][]byte
+	if f, err = load(b, RequestMagic, 2); err != nil {
+		return
+	}
+	id, list := Hash.New(), Bytes.New()
+	if err = decodeFields(f, id, list); err != nil {
+		return
+	}
+	packed := list.Get()
+	if len(packed)%4 != 0 {
+		return nil, errors.New("request segment list is not a multiple of 4 bytes")
+	}
+	r = &request{id: ID(*id.Get())}
+	for i := 0; i < len(packed); i += 4 {
+		r.missing = append(r.missing, binary.BigEndian.Uint32(packed[i:]))
+	}
+	return
+}
+
+func hashField(id ID) *Hash.Hash {
+	h := chainhash.Hash(id)
+	return &Hash.Hash{Hash: &h}
+}
+
+// load validates a message and returns its fields
+func load(b, magic []byte, fields int) (f [][]byte, err error) {
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(b, magic, fields); err != nil {
+		return
+	}
+	return c.Fields()
+}
+
+// decodeFields decodes each field of a message into the serializer in the same place, returning the first error
+func decodeFields(f [][]byte, into ...simplebuffer.Serializer) (err error) {
+	for i := range into {
+		if _, err = into[i].Decode(f[i]); err != nil {
+			return fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,311 @@
+package transport
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// BridgeMagic identifies the envelope packets bridges send to each other
+var BridgeMagic = []byte{'b', 'r', 'd', 'g'}
+
+const (
+	DefaultMaxHops     = 4
+	DefaultSeenTimeout = 10 * time.Second
+)
+
+// BridgeConfig is the configuration of a Bridge
+type BridgeConfig struct {
+	// Creator is the sender ID the bridge seals its envelopes with
+	Creator string
+	// Key is the pre shared key of the channel, packets that do not open with it are not forwarded
+	Key string
+	// Cipher is the cipher configuration of the channel, as in ChannelConfig
+	Cipher gcm.Config
+	// Port is the port of the broadcast channel on the local subnet
+	Port int
+	// Interface is the name of the network interface to join the broadcast channel on, empty for the system default
+	Interface string
+	// Listen is the unicast address remote bridges send to, empty if this bridge only sends
+	Listen string
+	// Remotes are the unicast addresses of the remote bridges to forward to
+	Remotes []string
+	// Magics limits forwarding to packets with these magics, all packets are forwarded if it is empty
+	Magics []string
+	// MaxHops is the number of bridges a packet may cross, zero selects DefaultMaxHops
+	MaxHops int
+	// MaxDatagramSize is the size of the receive buffers
+	MaxDatagramSize int
+}
+
+// BridgeMetrics are counters describing the traffic through a Bridge
+type BridgeMetrics struct {
+	// Forwarded is the number of packets sent to remote bridges
+	Forwarded atomic.Uint64
+	// Emitted is the number of packets from remote bridges sent onto the local broadcast channel
+	Emitted atomic.Uint64
+	// Duplicates is the number of packets dropped because they were already seen
+	Duplicates atomic.Uint64
+	// HopLimited is the number of packets not forwarded further because they crossed too many bridges
+	HopLimited atomic.Uint64
+	// Rejected is the number of packets that did not authenticate
+	Rejected atomic.Uint64
+}
+
+// Bridge joins a broadcast channel on one subnet and forwards the authenticated packets it receives unicast to remote
+// bridges, which emit them onto their own subnet's broadcast channel. Packets are forwarded still sealed, so nodes on
+// the far side decode them as usual, with the bridge as their source. Every packet is identified by its hash and
+// dropped when it comes back around, and carries a hop count that limits how many bridges it may cross.
+//
+// Where two bridges feed the same subnet, shards of one message can arrive from either of them, and receivers should
+// enable Channel.SetMultiPath. To bridge two interfaces of the same host run a bridge on each, with each other's
+// listen address on the loopback as their remote
+type Bridge struct {
+	BridgeConfig
+	Metrics     BridgeMetrics
+	ciph        cipher.AEAD
+	local       *net.UDPConn
+	localSender *net.UDPConn
+	link        *net.UDPConn
+	remotes     []*net.UDPAddr
+	magics      map[string]struct{}
+	seen        *seenCache
+	quit        chan struct{}
+}
+
+// NewBridge joins the broadcast channel and starts forwarding, until the quit channel is closed
+func NewBridge(cfg BridgeConfig, quit chan struct{}) (b *Bridge, err error) {
+	if cfg.MaxHops <= 0 {
+		cfg.MaxHops = DefaultMaxHops
+	}
+	if cfg.MaxDatagramSize <= 0 {
+		cfg.MaxDatagramSize = 8192
+	}
+	b = &Bridge{
+		BridgeConfig: cfg,
+		magics:       make(map[string]struct{}),
+		seen:         newSeenCache(DefaultSeenTimeout),
+		quit:         quit,
+	}
+	for i := range cfg.Magics {
+		b.magics[cfg.Magics[i]] = struct{}{}
+	}
+	if b.ciph, err = gcm.GetSuiteCipher(cfg.Key, cfg.Cipher); slog.Check(err) {
+		return
+	}
+	for i := range cfg.Remotes {
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr("udp4", cfg.Remotes[i]); slog.Check(err) {
+			return
+		}
+		b.remotes = append(b.remotes, addr)
+	}
+	var ifi *net.Interface
+	var laddr *net.UDPAddr
+	if cfg.Interface != "" {
+		if ifi, err = net.InterfaceByName(cfg.Interface); slog.Check(err) {
+			return
+		}
+		if laddr, err = interfaceAddr(ifi); slog.Check(err) {
+			return
+		}
+	}
+	group := &net.UDPAddr{IP: net.ParseIP(UDPMulticastAddress), Port: cfg.Port}
+	if b.local, err = net.ListenMulticastUDP("udp4", ifi, group); slog.Check(err) {
+		return
+	}
+	if err = b.local.SetReadBuffer(cfg.MaxDatagramSize); slog.Check(err) {
+	}
+	if b.localSender, err = net.DialUDP("udp4", laddr, group); slog.Check(err) {
+		return
+	}
+	// remote bridges are sent to from the listening socket, so they see the address they are configured with as the
+	// source and the bridge does not forward their packets back to them
+	var linkAddr *net.UDPAddr
+	if cfg.Listen != "" {
+		if linkAddr, err = net.ResolveUDPAddr("udp4", cfg.Listen); slog.Check(err) {
+			return
+		}
+	}
+	if b.link, err = net.ListenUDP("udp4", linkAddr); slog.Check(err) {
+		return
+	}
+	if err = b.link.SetReadBuffer(cfg.MaxDatagramSize); slog.Check(err) {
+	}
+	go func() {
+		<-quit
+		if err := b.local.Close(); slog.Check(err) {
+		}
+		if err := b.localSender.Close(); slog.Check(err) {
+		}
+		if err := b.link.Close(); slog.Check(err) {
+		}
+	}()
+	go b.readLocal()
+	go b.readLink()
+	slog.Debug("started bridge", cfg.Creator, "on", group, "link", b.link.LocalAddr(), "to", cfg.Remotes)
+	return
+}
+
+// LinkAddr returns the address of the socket the bridge exchanges envelopes with remote bridges on
+func (b *Bridge) LinkAddr() net.Addr {
+	return b.link.LocalAddr()
+}
+
+// readLocal forwards the packets from the local broadcast channel to the remote bridges
+func (b *Bridge) readLocal() {
+	buffer := make([]byte, b.MaxDatagramSize)
+	for {
+		n, _, err := b.local.ReadFromUDP(buffer)
+		if err != nil {
+			if handleNetworkError(b.local.LocalAddr().String(), err) == closed {
+				return
+			}
+			continue
+		}
+		packet := buffer[:n]
+		if !b.accept(packet) {
+			continue
+		}
+		// the first time a packet is seen it came from a node on the subnet, later it is our own emission coming back
+		if !b.seen.Add(packetID(packet)) {
+			b.Metrics.Duplicates.Inc()
+			continue
+		}
+		b.forward(packet, 1, nil)
+	}
+}
+
+// readLink emits the packets from remote bridges onto the local broadcast channel and passes them on to the other
+// remote bridges
+func (b *Bridge) readLink() {
+	// an envelope holds a packet of up to MaxDatagramSize with its hop count
+	buffer := make([]byte, b.MaxDatagramSize+1+PacketOverhead(b.ciph))
+	for {
+		n, src, err := b.link.ReadFromUDP(buffer)
+		if err != nil {
+			if handleNetworkError(b.link.LocalAddr().String(), err) == closed {
+				return
+			}
+			continue
+		}
+		magic, _, _, payload, err := OpenPacket(b.ciph, buffer[:n])
+		if err != nil || magic != string(BridgeMagic) || len(payload) < 1 {
+			b.Metrics.Rejected.Inc()
+			continue
+		}
+		hops, packet := int(payload[0]), payload[1:]
+		if !b.accept(packet) {
+			continue
+		}
+		if !b.seen.Add(packetID(packet)) {
+			b.Metrics.Duplicates.Inc()
+			continue
+		}
+		if _, err = b.localSender.Write(packet); slog.Check(err) {
+		} else {
+			b.Metrics.Emitted.Inc()
+		}
+		if hops >= b.MaxHops {
+			b.Metrics.HopLimited.Inc()
+			continue
+		}
+		b.forward(packet, hops+1, src)
+	}
+}
+
+// accept returns true if the packet opens with the channel key and has a magic that is forwarded
+func (b *Bridge) accept(packet []byte) bool {
+	magic, err := authenticate(b.ciph, packet)
+	if err != nil {
+		b.Metrics.Rejected.Inc()
+		return false
+	}
+	if magic == string(BridgeMagic) {
+		return false
+	}
+	if len(b.magics) > 0 {
+		if _, ok := b.magics[magic]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// forward sends a packet in an envelope with its hop count to every remote bridge except the one it came from
+func (b *Bridge) forward(packet []byte, hops int, from *net.UDPAddr) {
+	envelope, err := EncryptMessage(b.Creator, b.ciph, BridgeMagic, nil, append([]byte{byte(hops)}, packet...))
+	if slog.Check(err) {
+		return
+	}
+	for i := range b.remotes {
+		if from != nil && b.remotes[i].IP.Equal(from.IP) && b.remotes[i].Port == from.Port {
+			continue
+		}
+		if _, err = b.link.WriteToUDP(envelope, b.remotes[i]); slog.Check(err) {
+			continue
+		}
+		b.Metrics.Forwarded.Inc()
+	}
+}
+
+// interfaceAddr returns the first IPv4 address of an interface
+func interfaceAddr(ifi *net.Interface) (addr *net.UDPAddr, err error) {
+	var addrs []net.Addr
+	if addrs, err = ifi.Addrs(); err != nil {
+		return
+	}
+	for i := range addrs {
+		if ipn, ok := addrs[i].(*net.IPNet); ok && ipn.IP.To4() != nil {
+			return &net.UDPAddr{IP: ipn.IP}, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprint("interface ", ifi.Name, " has no IPv4 address"))
+}
+
+// packetID is the message ID a bridge uses to recognise a packet it has already handled
+func packetID(packet []byte) string {
+	h := sha256.Sum256(packet)
+	return string(h[:16])
+}
+
+// seenCache remembers IDs for a limited time
+type seenCache struct {
+	sync.Mutex
+	ids       map[string]time.Time
+	timeout   time.Duration
+	lastEvict time.Time
+}
+
+func newSeenCache(timeout time.Duration) *seenCache {
+	return &seenCache{ids: make(map[string]time.Time), timeout: timeout}
+}
+
+// Add records an ID and returns false if it was already present
+func (s *seenCache) Add(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastEvict) >= s.timeout/4 {
+		s.lastEvict = now
+		for i := range s.ids {
+			if now.Sub(s.ids[i]) > s.timeout {
+				delete(s.ids, i)
+			}
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = now
+	return true
+}
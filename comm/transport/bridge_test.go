@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBridge(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	const key = "bridge test"
+	// the two subnets are simulated by two broadcast channel ports
+	nearPort, farPort := 11871, 11872
+	far, err := NewBridge(BridgeConfig{Creator: "far", Key: key, Port: farPort, Listen: "127.0.0.1:0"}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	near, err := NewBridge(BridgeConfig{Creator: "near", Key: key, Port: nearPort,
+		Remotes: []string{far.LinkAddr().String()}}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+	handlers := Handlers{
+		"test": func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+			received <- b
+			return
+		},
+	}
+	if _, err = NewBroadcastChannel("receiver", nil, key, farPort, 8192, handlers, quit); err != nil {
+		t.Fatal(err)
+	}
+	sender, err := NewBroadcastChannel("sender", nil, key, nearPort, 8192, Handlers{}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("across the bridge")
+	if err = sender.SendMessage([]byte("test"), message); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, message) {
+			t.Fatal("received", string(b), "expected", string(message))
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("message did not cross the bridge")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if near.Metrics.Forwarded.Load() != 9 || far.Metrics.Emitted.Load() != 9 {
+		t.Fatal("expected 9 packets forwarded and emitted, got", near.Metrics.Forwarded.Load(),
+			far.Metrics.Emitted.Load())
+	}
+	// the far bridge hears its own emissions on its subnet and must not send them on
+	if far.Metrics.Duplicates.Load() != 9 || far.Metrics.Forwarded.Load() != 0 {
+		t.Fatal("far bridge did not drop its own emissions")
+	}
+}
@@ -0,0 +1,276 @@
+package transport
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
+	"github.com/p9c/pkg/coding/simplebuffer"
+	"github.com/p9c/pkg/coding/simplebuffer/Bytes"
+	"github.com/p9c/pkg/coding/simplebuffer/String"
+	"github.com/p9c/pkg/coding/simplebuffer/Time"
+)
+
+// CaptureMagic identifies a captured packet record in a capture file
+var CaptureMagic = []byte{'t', 'c', 'a', 'p'}
+
+const (
+	// MaxDatagramSize is the largest payload of a UDP datagram, and so of a captured packet
+	MaxDatagramSize = 65535
+	// MaxCaptureSourceLength is the longest source address recorded with a captured packet
+	MaxCaptureSourceLength = 255
+	// maxCaptureRecord is the size of a capture record holding the largest packet and source address: the container
+	// header with its three offsets, the time, and the source and data with their length prefixes
+	maxCaptureRecord = 10 + 3*4 + 8 + 4 + MaxCaptureSourceLength + 4 + MaxDatagramSize
+)
+
+// CapturedPacket is a raw packet as it was read from the socket, before decryption
+type CapturedPacket struct {
+	Time   time.Time
+	Source string
+	Data   []byte
+}
+
+// WriteCapture appends a packet record to a capture file. Records are simplebuffer containers written back to back,
+// the size field in the container header delimits them
+func WriteCapture(w io.Writer, p *CapturedPacket) (err error) {
+	if len(p.Data) > MaxDatagramSize || len(p.Source) > MaxCaptureSourceLength {
+		return errors.New("captured packet is too large to record")
+	}
+	c := simplebuffer.Serializers{
+		Time.New().Put(p.Time),
+		String.New().Put(p.Source),
+		Bytes.New().Put(p.Data),
+	}.CreateContainer(CaptureMagic)
+	_, err = w.Write(c.Data)
+	return
+}
+
+// ReadCapture reads the next packet record from a capture file. At the end of the file it returns io.EOF
+func ReadCapture(r io.Reader) (p *CapturedPacket, err error) {
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	if string(header[:4]) != string(CaptureMagic) {
+		err = errors.New("not a capture record")
+		return
+	}
+	size := binary.BigEndian.Uint32(header[4:8])
+	if size < 8 {
+		err = errors.New("capture record size is smaller than its header")
+		return
+	}
+	if size > maxCaptureRecord {
+		err = fmt.Errorf("capture record size %d is larger than the largest packet record", size)
+		return
+	}
+	data := make([]byte, size)
+	copy(data, header)
+	if _, err = io.ReadFull(r, data[8:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	var c *simplebuffer.Container
+	if c, err = simplebuffer.Load(data, CaptureMagic, 3); err != nil {
+		return
+	}
+	var f [][]byte
+	if f, err = c.Fields(); err != nil {
+		return
+	}
+	p = &CapturedPacket{
+		Time:   Time.New().DecodeOne(f[0]).Get(),
+		Source: String.New().DecodeOne(f[1]).Get(),
+		Data:   Bytes.New().DecodeOne(f[2]).Get(),
+	}
+	return
+}
+
+// ReadCaptureFile reads all of the packet records from a capture file
+func ReadCaptureFile(r io.Reader) (packets []*CapturedPacket, err error) {
+	for {
+		var p *CapturedPacket
+		if p, err = ReadCapture(r); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		packets = append(packets, p)
+	}
+}
+
+// Capture reads packets from a connection and writes them to w with the time they were received until the quit
+// channel is closed or the connection is closed. If a cipher is given only packets that open with it are recorded
+func Capture(conn *net.UDPConn, ciph cipher.AEAD, w io.Writer, maxDatagramSize int, quit chan struct{}) (
+	count int, err error) {
+	buffer := make([]byte, maxDatagramSize)
+	go func() {
+		<-quit
+		if err := conn.Close(); slog.Check(err) {
+		}
+	}()
+	var numBytes int
+	var src net.Addr
+	for {
+		if numBytes, src, err = conn.ReadFromUDP(buffer); err != nil {
+			if handleNetworkError(conn.LocalAddr().String(), err) == closed {
+				err = nil
+				return
+			}
+			continue
+		}
+		data := make([]byte, numBytes)
+		copy(data, buffer[:numBytes])
+		if ciph != nil && !canOpen(ciph, data) {
+			continue
+		}
+		if err = WriteCapture(w, &CapturedPacket{Time: time.Now(), Source: src.String(), Data: data}); slog.Check(err) {
+			return
+		}
+		count++
+	}
+}
+
+func canOpen(ciph cipher.AEAD, data []byte) bool {
+	_, err := authenticate(ciph, data)
+	return err == nil
+}
+
+// Replay writes captured packets to a connection, preserving the intervals between them divided by speed. A speed of
+// zero or less sends them as fast as possible
+func Replay(conn *net.UDPConn, packets []*CapturedPacket, speed float64, quit chan struct{}) (err error) {
+	for i := range packets {
+		if i > 0 && speed > 0 {
+			gap := time.Duration(float64(packets[i].Time.Sub(packets[i-1].Time)) / speed)
+			select {
+			case <-time.After(gap):
+			case <-quit:
+				return
+			}
+		}
+		if _, err = conn.Write(packets[i].Data); slog.Check(err) {
+			return
+		}
+	}
+	return
+}
+
+// PacketInfo is the decoded description of a captured packet
+type PacketInfo struct {
+	*CapturedPacket
+	Magic string
+	// Nonce is the nonce the packet was sealed with, which follows the magic, or the tag of a topic packet
+	Nonce []byte
+	// ID is the message ID sealed in the packet
+	ID []byte
+	// Sender is the sender ID sealed in the packet
+	Sender string
+	// Opened is true if the packet decrypted with the cipher
+	Opened bool
+	// Shard is the codec's ID of the shard carried by the packet, -1 if it could not be opened
+	Shard int
+	// Received is the number of shards of the message seen so far, including this one
+	Received int
+	// Decoded is true if the message was reassembled by this packet or an earlier one
+	Decoded bool
+	// Message is the reassembled message when this packet completed it
+	Message []byte
+}
+
+// String renders a packet description as a single line
+func (p *PacketInfo) String() (s string) {
+	s = fmt.Sprintf("%s %-21s magic %q nonce %s len %d",
+		p.Time.Format("15:04:05.000000"), p.Source, p.Magic, hex.EncodeToString(p.Nonce), len(p.Data))
+	if p.Opened {
+		s += fmt.Sprintf(" id %s sender %q", hex.EncodeToString(p.ID), p.Sender)
+	}
+	switch {
+	case !p.Opened:
+		s += " not opened"
+	case p.Message != nil:
+		s += fmt.Sprintf(" shard %d (%d received) decoded %d bytes", p.Shard, p.Received, len(p.Message))
+	case p.Decoded:
+		s += fmt.Sprintf(" shard %d (%d received) already decoded", p.Shard, p.Received)
+	default:
+		s += fmt.Sprintf(" shard %d (%d received) partial", p.Shard, p.Received)
+	}
+	return
+}
+
+// DecodeCapture opens captured packets with a cipher and reassembles their shards with the codec, DefaultCodec if it is
+// nil, the same way as Handle, reporting the state of each packet's message as of that packet's arrival
+func DecodeCapture(ciph cipher.AEAD, codec coding.Codec, packets []*CapturedPacket) (infos []*PacketInfo) {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	type message struct {
+		shards  [][]byte
+		decoded bool
+	}
+	messages := make(map[string]*message)
+	for i := range packets {
+		info := &PacketInfo{CapturedPacket: packets[i], Shard: -1}
+		infos = append(infos, info)
+		data := packets[i].Data
+		if len(data) >= 4 {
+			info.Magic = string(data[:4])
+		}
+		nonceAt := 4
+		if info.Magic == string(TopicMagic) {
+			nonceAt += TopicTagSize
+		}
+		if nL := ciph.NonceSize(); len(data) >= nonceAt+nL {
+			info.Nonce = data[nonceAt : nonceAt+nL]
+		}
+		var id []byte
+		var sender string
+		var shard []byte
+		var err error
+		if info.Magic == string(TopicMagic) {
+			// a topic message is identified by its tag and message ID
+			var tag []byte
+			if tag, info.ID, sender, shard, err = OpenTopicPacket(ciph, data); err == nil {
+				id = append(append(id, tag...), info.ID...)
+			}
+		} else if _, info.ID, sender, shard, err = OpenPacket(ciph, data); err == nil {
+			id = info.ID
+		}
+		if err != nil || len(shard) < 1 {
+			continue
+		}
+		info.Sender = sender
+		info.Opened = true
+		if info.Shard, err = codec.ShardID(shard); err != nil {
+			info.Shard = -1
+			continue
+		}
+		key := messageKey(packets[i].Source, sender, string(id), false)
+		m, ok := messages[key]
+		if !ok {
+			m = &message{}
+			messages[key] = m
+		}
+		m.shards = append(m.shards, shard)
+		info.Received = len(m.shards)
+		if min, err := codec.MinShards(shard); !m.decoded && err == nil && len(m.shards) >= min {
+			if info.Message, err = codec.Decode(m.shards); err == nil {
+				m.decoded = true
+			} else {
+				info.Message = nil
+			}
+		}
+		info.Decoded = m.decoded
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	ciph, err := gcm.GetCipher("capture test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("the quick brown fox jumps over the lazy dog")
+	var file bytes.Buffer
+	start := time.Now()
+	shards, err := GetShards(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range shards {
+		var packet []byte
+		if packet, err = EncryptMessage("test", ciph, []byte("test"), id, shards[i]); err != nil {
+			t.Fatal(err)
+		}
+		p := &CapturedPacket{Time: start.Add(time.Duration(i) * time.Millisecond), Source: "127.0.0.1:11049",
+			Data: packet}
+		if err = WriteCapture(&file, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packets, err := ReadCaptureFile(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(shards) {
+		t.Fatal("expected", len(shards), "packets, got", len(packets))
+	}
+	if !packets[1].Time.Equal(start.Add(time.Millisecond)) || packets[1].Source != "127.0.0.1:11049" {
+		t.Fatal("packet metadata did not survive the round trip")
+	}
+	infos := DecodeCapture(ciph, nil, packets)
+	nonces := make(map[string]bool)
+	for i := range infos {
+		if !infos[i].Opened || infos[i].Magic != "test" || infos[i].Shard != i {
+			t.Fatal("packet", i, "was not decoded correctly:", infos[i])
+		}
+		if !bytes.Equal(infos[i].Nonce, packets[i].Data[4:4+ciph.NonceSize()]) || nonces[string(infos[i].Nonce)] {
+			t.Fatal("packet", i, "has the wrong nonce:", infos[i])
+		}
+		nonces[string(infos[i].Nonce)] = true
+		switch {
+		case i < 2:
+			if infos[i].Decoded {
+				t.Fatal("message decoded before enough shards arrived")
+			}
+		case i == 2:
+			if !bytes.Equal(infos[i].Message, message) {
+				t.Fatal("message was not reassembled by the third shard")
+			}
+		default:
+			if !infos[i].Decoded || infos[i].Message != nil {
+				t.Fatal("late shard", i, "should report the message as already decoded")
+			}
+		}
+	}
+}
+
+func TestCaptureRecordSize(t *testing.T) {
+	var file bytes.Buffer
+	p := &CapturedPacket{Time: time.Now(), Source: string(make([]byte, MaxCaptureSourceLength)),
+		Data: make([]byte, MaxDatagramSize)}
+	if err := WriteCapture(&file, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCapture(&file); err != nil {
+		t.Fatal("the largest record did not read back", err)
+	}
+	p.Data = append(p.Data, 0)
+	if err := WriteCapture(&file, p); err == nil {
+		t.Fatal("an oversized packet was recorded")
+	}
+	// a corrupt size is rejected before anything is allocated for it
+	header := append(append([]byte{}, CaptureMagic...), 0xff, 0xff, 0xff, 0xff)
+	if _, err := ReadCapture(bytes.NewReader(header)); err == nil {
+		t.Fatal("a record claiming 4GB was accepted")
+	}
+}
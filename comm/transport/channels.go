@@ -9,9 +9,13 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
 	"github.com/p9c/pkg/coding/fek"
 
 	"github.com/p9c/pkg/coding/gcm"
@@ -31,25 +35,53 @@ type (
 		First   time.Time
 		Decoded bool
 		Source  net.Addr
+		// ids are the codec's IDs of the shards in Buffers
+		ids []int
+		// min is the codec's minimum number of shards for the message
+		min int
 	}
 	// HandlerFunc is a function that is used to process a received message
 	HandlerFunc func(ctx interface{}, src net.Addr, dst string, b []byte) (err error)
 	Handlers    map[string]HandlerFunc
 	Channel     struct {
-		buffers         map[string]*MsgBuffer
+		buffers         *reassembler
+		codec           atomic.Value
 		Ready           chan struct{}
 		context         interface{}
 		Creator         string
 		firstSender     *string
 		lastSent        *time.Time
 		MaxDatagramSize int
-		receiveCiph     cipher.AEAD
+		multiPath       atomic.Bool
+		ciphers         atomic.Value
 		Receiver        *net.UDPConn
-		sendCiph        cipher.AEAD
 		Sender          *net.UDPConn
+		topics          *Topics
 	}
 )
 
+// channelCiphers are the ciphers a channel seals and opens packets with, held in Channel.ciphers and swapped whole
+type channelCiphers struct {
+	send, receive cipher.AEAD
+}
+
+// SetCipher replaces the cipher of the channel, as when rekeying after a gcm.Limited cipher reports it is due. Packets
+// being sent or received while it is changed use either the old or the new cipher, and those in flight with the old one
+// are dropped once it has changed, so every party should switch at about the same time. Topic tags keep the key the
+// channel was created with
+func (c *Channel) SetCipher(ciph cipher.AEAD) {
+	// an AEAD holds no state between calls so one serves both directions
+	c.ciphers.Store(&channelCiphers{send: ciph, receive: ciph})
+}
+
+func (c *Channel) sendCiph() cipher.AEAD {
+	return c.ciphers.Load().(*channelCiphers).send
+}
+
+func (c *Channel) receiveCiph() cipher.AEAD {
+	return c.ciphers.Load().(*channelCiphers).receive
+}
+
 // SetDestination changes the address the outbound connection of a channel directs to
 func (c *Channel) SetDestination(dst string) (err error) {
 	slog.Debug("sending to", dst)
@@ -58,8 +90,15 @@ func (c *Channel) SetDestination(dst string) (err error) {
 	return
 }
 
-// Send fires off some data through the configured channel's outbound.
-func (c *Channel) Send(magic []byte, nonce []byte, data []byte) (
+// SetMultiPath sets whether shards of a message may arrive from more than one source address, as happens when a sender
+// is reachable by several paths. They must still all be sealed by the same sender
+func (c *Channel) SetMultiPath(allow bool) {
+	c.multiPath.Store(allow)
+}
+
+// Send fires off some data through the configured channel's outbound. The packet is sealed under a new nonce, with
+// the message ID if it is given or a new one otherwise
+func (c *Channel) Send(magic []byte, id []byte, data []byte) (
 	n int, err error) {
 	if len(data) == 0 {
 		err = errors.New("not sending empty packet")
@@ -67,32 +106,74 @@ func (c *Channel) Send(magic []byte, nonce []byte, data []byte) (
 		return
 	}
 	var msg []byte
-	if msg, err = EncryptMessage(c.Creator, c.sendCiph, magic, nonce, data,
+	if msg, err = EncryptMessage(c.Creator, c.sendCiph(), magic, id, data,
 	); slog.
 		Check(err) {
+		return
 	}
 	n, err = c.Sender.Write(msg)
 	// DEBUG(msg)
 	return
 }
 
-// SendMany sends a BufIter of shards as produced by GetShards
+// SendMany sends a BufIter of shards as produced by GetShards, each sealed under its own nonce with a message ID they
+// share
 func (c *Channel) SendMany(magic []byte, b [][]byte) (err error) {
-	if nonce, err := GetNonce(c.sendCiph); slog.Check(err) {
+	var id []byte
+	if id, err = NewMessageID(); slog.Check(err) {
 	} else {
 		for i := 0; i < len(b); i++ {
 			// DEBUG(i)
-			if _, err = c.Send(magic, nonce, b[i]); slog.Check(err) {
+			if _, err = c.Send(magic, id, b[i]); slog.Check(err) {
 				// debug.PrintStack()
+				if errors.Is(err, gcm.ErrLimitReached) {
+					return
+				}
 			}
 		}
 		slog.Debug(c.Creator, "sent packets", string(magic),
-			hex.EncodeToString(nonce), c.Sender.LocalAddr(),
+			hex.EncodeToString(id), c.Sender.LocalAddr(),
 			c.Sender.RemoteAddr())
 	}
 	return
 }
 
+// SendMessage encodes a message into fec shards with the channel's codec and sends them. The shards are encoded into a
+// pooled buffer as they are sealed into packets before it returns
+func (c *Channel) SendMessage(magic, data []byte) (err error) {
+	b := shardsPool.Get().(*shardsBuffer)
+	defer shardsPool.Put(b)
+	if b.shards, err = c.Codec().EncodeInto(b.shards, data); slog.Check(err) {
+		return
+	}
+	return c.SendMany(magic, b.shards)
+}
+
+// shardsBuffer holds the memory of the shards of a message between sends
+type shardsBuffer struct {
+	shards [][]byte
+}
+
+var shardsPool = sync.Pool{
+	New: func() interface{} {
+		return new(shardsBuffer)
+	},
+}
+
+// SetCodec changes the fec codec the channel encodes and decodes messages with. Both ends of a channel must use the
+// same codec
+func (c *Channel) SetCodec(codec coding.Codec) {
+	c.codec.Store(codec)
+}
+
+// Codec returns the fec codec of the channel, which is DefaultCodec unless changed with SetCodec
+func (c *Channel) Codec() coding.Codec {
+	if codec, ok := c.codec.Load().(coding.Codec); ok {
+		return codec
+	}
+	return DefaultCodec
+}
+
 // Close the channel
 func (c *Channel) Close() (err error) {
 	// if err = c.Sender.Close(); Check(err) {
@@ -102,11 +183,13 @@ func (c *Channel) Close() (err error) {
 	return
 }
 
+// DefaultCodec is the fec codec channels use unless configured otherwise
+var DefaultCodec = fek.Codec
+
 // GetShards returns a buffer iterator to feed to Channel.SendMany containing fec encoded shards built from the provided
-// buffer
-func GetShards(data []byte) (shards [][]byte) {
-	var err error
-	if shards, err = fek.Encode(data); slog.Check(err) {
+// buffer with DefaultCodec
+func GetShards(data []byte) (shards [][]byte, err error) {
+	if shards, err = DefaultCodec.Encode(data); slog.Check(err) {
 	}
 	return
 }
@@ -114,23 +197,36 @@ func GetShards(data []byte) (shards [][]byte) {
 // NewUnicastChannel sets up a listener and sender for a specified destination
 func NewUnicastChannel(creator string, ctx interface{}, key, sender,
 	receiver string, maxDatagramSize int, handlers Handlers, quit chan struct{},
+) (
+	channel *Channel, err error) {
+	var ciph cipher.AEAD
+	var tagKey []byte
+	if ciph, tagKey, err = channelKeys(key, gcm.Config{}); slog.Check(err) {
+		return
+	}
+	return newUnicastChannel(creator, ctx, ciph, tagKey, sender, receiver, maxDatagramSize, handlers, quit)
+}
+
+// newUnicastChannel sets up a unicast channel with the given cipher and topic tag key
+func newUnicastChannel(creator string, ctx interface{}, ciph cipher.AEAD, tagKey []byte, sender,
+	receiver string, maxDatagramSize int, handlers Handlers, quit chan struct{},
 ) (
 	channel *Channel, err error) {
 	channel = &Channel{
 		Creator:         creator,
 		MaxDatagramSize: maxDatagramSize,
-		buffers:         make(map[string]*MsgBuffer),
+		buffers:         newReassembler(),
 		context:         ctx,
+		topics:          newTopics(),
+		Ready:           make(chan struct{}),
 	}
 	var magics []string
 
 	for i := range handlers {
 		magics = append(magics, i)
 	}
-	if channel.sendCiph, err = gcm.GetCipher(key); slog.Check(err) {
-	}
-	if channel.receiveCiph, err = gcm.GetCipher(key); slog.Check(err) {
-	}
+	channel.SetCipher(ciph)
+	channel.topics.key = tagKey
 	channel.Receiver, err = Listen(receiver, channel, maxDatagramSize,
 		handlers, quit)
 	channel.Sender, err = NewSender(sender, maxDatagramSize)
@@ -139,6 +235,7 @@ func NewUnicastChannel(creator string, ctx interface{}, key, sender,
 	}
 	slog.Warn("starting unicast channel:", channel.Creator, sender,
 		receiver, magics)
+	close(channel.Ready)
 	return
 }
 
@@ -184,25 +281,32 @@ func Listen(address string, channel *Channel, maxDatagramSize int,
 // NewBroadcastChannel returns a broadcaster and listener with a given handler on a multicast address and specified
 // port. The handlers define the messages that will be processed and any other messages are ignored
 func NewBroadcastChannel(creator string, ctx interface{}, key string, port int,
+	maxDatagramSize int, handlers Handlers, quit chan struct{}) (
+	channel *Channel, err error) {
+	var ciph cipher.AEAD
+	var tagKey []byte
+	if ciph, tagKey, err = channelKeys(key, gcm.Config{}); slog.Check(err) {
+	}
+	if ciph == nil {
+		panic("nil send cipher")
+	}
+	return newBroadcastChannel(creator, ctx, ciph, tagKey, port, maxDatagramSize, handlers, quit)
+}
+
+// newBroadcastChannel sets up a broadcast channel with the given cipher and topic tag key
+func newBroadcastChannel(creator string, ctx interface{}, ciph cipher.AEAD, tagKey []byte, port int,
 	maxDatagramSize int, handlers Handlers, quit chan struct{}) (
 	channel *Channel, err error) {
 	channel = &Channel{
 		Creator:         creator,
 		MaxDatagramSize: maxDatagramSize,
-		buffers:         make(map[string]*MsgBuffer),
+		buffers:         newReassembler(),
 		context:         ctx,
+		topics:          newTopics(),
 		Ready:           make(chan struct{}),
 	}
-	if channel.sendCiph, err = gcm.GetCipher(key); slog.Check(err) {
-	}
-	if channel.sendCiph == nil {
-		panic("nil send cipher")
-	}
-	if channel.receiveCiph, err = gcm.GetCipher(key); slog.Check(err) {
-	}
-	if channel.receiveCiph == nil {
-		panic("nil receive cipher")
-	}
+	channel.SetCipher(ciph)
+	channel.topics.key = tagKey
 	if channel.Receiver, err = ListenBroadcast(port, channel, maxDatagramSize,
 		handlers, quit); slog.Check(err) {
 	}
@@ -264,7 +368,9 @@ func handleNetworkError(address string, err error) (result int) {
 }
 
 // Handle listens for messages, decodes them, aggregates them, recovers the data from the reed solomon fec shards
-// received and invokes the handler provided matching the magic on the complete received messages
+// received and invokes the handler provided matching the magic on the complete received messages. Handlers are called
+// on the goroutine reading the socket, NewChannel wraps them in Dispatchers unless configured not to, callers of the
+// lower level constructors should do the same with Handlers.Dispatch for handlers that may be slow
 func Handle(address string, channel *Channel,
 	handlers Handlers, maxDatagramSize int, quit chan struct{}) {
 	buffer := make([]byte, maxDatagramSize)
@@ -295,69 +401,39 @@ out:
 		}
 		// Filter messages by magic, if there is no match in the map the packet is ignored
 		magic := string(buffer[:4])
+		// topic messages are filtered by subscription before they are deciphered
+		if magic == string(TopicMagic) {
+			channel.handleTopic(address, src, buffer[:numBytes])
+			continue
+		}
 		if handler, ok := handlers[magic]; ok {
 			// if caller needs to know the liveness status of the controller it is working on, the code below
 			if channel.lastSent != nil && channel.firstSender != nil {
 				*channel.lastSent = time.Now()
 			}
-			msg := buffer[:numBytes]
-			nL := channel.receiveCiph.NonceSize()
-			nonceBytes := msg[4 : 4+nL]
-			nonce := string(nonceBytes)
-			// if nonce == seenNonce {
-			// 	DEBUG("seen this one")
-			// 	continue
-			// }
-			// seenNonce = nonce
 			// decipher
+			var id []byte
+			var sender string
 			var shard []byte
-			if shard, err = channel.receiveCiph.Open(nil, nonceBytes,
-				msg[4+len(nonceBytes):], nil); err != nil {
+			if _, id, sender, shard, err = OpenPacket(channel.receiveCiph(), buffer[:numBytes]); err != nil {
 				continue
 			}
 			// DEBUG("read", numBytes, "from", src, err, hex.EncodeToString(msg))
-			if bn, ok := channel.buffers[nonce]; ok {
-				if !bn.Decoded {
-					bn.Buffers = append(bn.Buffers, shard)
-					if len(bn.Buffers) >= 3 {
-						// DEBUG(len(bn.Buffers))
-						// try to decode it
-						var cipherText []byte
-						cipherText, err = fek.Decode(bn.Buffers)
-						if err != nil {
-							slog.Error(err)
-							continue
-						}
-						bn.Decoded = true
-						// DEBUG(numBytes, src, err)
-						slog.Debugf("received packet with magic %s from %s",
-							magic, src.String())
-						if err = handler(channel.context, src, address, cipherText,
-						); slog.Check(err) {
-							continue
-						}
-						// src = nil
-						// buffer = buffer[:0]
-					}
-				} else {
-					// if nonce == seenNonce {
-					// 	continue
-					// }
-					// seenNonce = nonce
-					for i := range channel.buffers {
-						if i != nonce || (channel.buffers[i].Decoded &&
-							len(channel.buffers[i].Buffers) > 8) {
-							// superseded messages can be deleted from the buffers, we don't add more data for the
-							// already decoded.
-							delete(channel.buffers, i)
-						}
-					}
+			// shards of any number of messages may be interleaved, each is collected under its source, sender and
+			// message ID until it can be decoded, late shards of decoded messages are discarded
+			var cipherText []byte
+			key := messageKey(src.String(), sender, string(id), channel.multiPath.Load())
+			if cipherText, err = channel.buffers.Add(key, src, shard, channel.Codec()); err != nil {
+				slog.Error(err)
+				continue
+			}
+			if cipherText != nil {
+				slog.Debugf("received packet with magic %s from %s",
+					magic, src.String())
+				if err = handler(channel.context, src, address, cipherText,
+				); slog.Check(err) {
+					continue
 				}
-			} else {
-				channel.buffers[nonce] = &MsgBuffer{[][]byte{},
-					time.Now(), false, src}
-				channel.buffers[nonce].Buffers = append(channel.buffers[nonce].
-					Buffers, shard)
 			}
 		}
 		// for i := range buffer {
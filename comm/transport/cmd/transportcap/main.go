@@ -0,0 +1,185 @@
+// Command transportcap records the raw packets of a transport channel to a file, decodes capture files offline and
+// replays them onto a channel to reproduce field issues
+package main
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/p9c/pkg/app/disrupt"
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
+	"github.com/p9c/pkg/coding/gcm"
+	"github.com/p9c/pkg/comm/transport"
+)
+
+func main() {
+	a := cli.NewApp()
+	a.Name = "transportcap"
+	a.Usage = "capture, decode and replay transport channel packets"
+	channelFlags := []cli.Flag{
+		cli.IntFlag{Name: "port, p", Value: transport.DefaultPort, Usage: "multicast channel port"},
+		cli.StringFlag{Name: "address, a", Usage: "unicast address to use instead of the multicast channel"},
+		cli.IntFlag{Name: "size, s", Value: 8192, Usage: "maximum datagram size"},
+	}
+	suiteFlag := cli.StringFlag{Name: "suite", Value: string(gcm.DefaultSuite),
+		Usage: fmt.Sprint("cipher suite of the channel, one of ", gcm.Suites)}
+	saltFlag := cli.StringFlag{Name: "salt", Usage: "key derivation salt of the channel in hex, if it has one"}
+	a.Commands = []cli.Command{
+		{
+			Name:   "record",
+			Usage:  "write packets received on a channel to a capture file",
+			Action: record,
+			Flags: append(channelFlags,
+				cli.StringFlag{Name: "key, k", Usage: "pre shared key, if set only packets that open with it are kept"},
+				cli.StringFlag{Name: "out, o", Value: "transport.cap", Usage: "capture file to write"},
+				suiteFlag, saltFlag,
+			),
+		},
+		{
+			Name:   "decode",
+			Usage:  "print the magic, message and sender IDs, shard and reassembly state of each captured packet",
+			Action: decode,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "key, k", Usage: "pre shared key of the channel"},
+				cli.StringFlag{Name: "in, i", Value: "transport.cap", Usage: "capture file to read"},
+				cli.BoolFlag{Name: "messages, m", Usage: "also print the decoded messages"},
+				cli.StringFlag{Name: "codec, c", Value: transport.DefaultCodec.Name(),
+					Usage: fmt.Sprint("fec codec of the channel, one of ", coding.Codecs())},
+				suiteFlag, saltFlag,
+			},
+		},
+		{
+			Name:   "replay",
+			Usage:  "send captured packets onto a channel",
+			Action: replay,
+			Flags: append(channelFlags,
+				cli.StringFlag{Name: "in, i", Value: "transport.cap", Usage: "capture file to read"},
+				cli.Float64Flag{Name: "speed", Value: 1, Usage: "timing multiplier, 0 sends without delay"},
+			),
+		},
+	}
+	if err := a.Run(os.Args); slog.Check(err) {
+		os.Exit(1)
+	}
+}
+
+func record(c *cli.Context) (err error) {
+	var conn *net.UDPConn
+	if c.String("address") != "" {
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr("udp4", c.String("address")); slog.Check(err) {
+			return
+		}
+		if conn, err = net.ListenUDP("udp4", addr); slog.Check(err) {
+			return
+		}
+	} else {
+		addr := &net.UDPAddr{IP: net.ParseIP(transport.UDPMulticastAddress), Port: c.Int("port")}
+		if conn, err = net.ListenMulticastUDP("udp4", nil, addr); slog.Check(err) {
+			return
+		}
+	}
+	var f *os.File
+	if f, err = os.Create(c.String("out")); slog.Check(err) {
+		return
+	}
+	defer func() {
+		if err := f.Close(); slog.Check(err) {
+		}
+	}()
+	var ciph cipher.AEAD
+	if c.String("key") != "" {
+		if ciph, err = getCipher(c); slog.Check(err) {
+			return
+		}
+	}
+	quit := make(chan struct{})
+	disrupt.AddHandler(func() {
+		close(quit)
+	})
+	slog.Info("recording", conn.LocalAddr(), "to", c.String("out"))
+	var count int
+	count, err = transport.Capture(conn, ciph, f, c.Int("size"), quit)
+	slog.Info("recorded", count, "packets")
+	return
+}
+
+func decode(c *cli.Context) (err error) {
+	if c.String("key") == "" {
+		return errors.New("the pre shared key is required to decode packets")
+	}
+	var packets []*transport.CapturedPacket
+	if packets, err = readFile(c.String("in")); slog.Check(err) {
+		return
+	}
+	ciph, err := getCipher(c)
+	if slog.Check(err) {
+		return
+	}
+	codec, err := coding.GetCodec(c.String("codec"))
+	if slog.Check(err) {
+		return
+	}
+	for _, info := range transport.DecodeCapture(ciph, codec, packets) {
+		fmt.Println(info)
+		if c.Bool("messages") && info.Message != nil {
+			fmt.Printf("%q\n", info.Message)
+		}
+	}
+	return
+}
+
+func replay(c *cli.Context) (err error) {
+	var packets []*transport.CapturedPacket
+	if packets, err = readFile(c.String("in")); slog.Check(err) {
+		return
+	}
+	var conn *net.UDPConn
+	if c.String("address") != "" {
+		conn, err = transport.NewSender(c.String("address"), c.Int("size"))
+	} else {
+		conn, err = transport.NewBroadcaster(c.Int("port"), c.Int("size"))
+	}
+	if slog.Check(err) {
+		return
+	}
+	quit := make(chan struct{})
+	disrupt.AddHandler(func() {
+		close(quit)
+	})
+	slog.Info("replaying", len(packets), "packets to", conn.RemoteAddr())
+	return transport.Replay(conn, packets, c.Float64("speed"), quit)
+}
+
+func readFile(name string) (packets []*transport.CapturedPacket, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer func() {
+		if err := f.Close(); slog.Check(err) {
+		}
+	}()
+	return transport.ReadCaptureFile(f)
+}
+
+// getCipher derives the channel cipher from the key, suite and salt flags
+func getCipher(c *cli.Context) (ciph cipher.AEAD, err error) {
+	var cfg gcm.Config
+	if cfg.Suite, err = gcm.ParseSuite(c.String("suite")); slog.Check(err) {
+		return
+	}
+	if c.String("salt") != "" {
+		if cfg.Salt, err = hex.DecodeString(c.String("salt")); slog.Check(err) {
+			return
+		}
+	}
+	return gcm.GetSuiteCipher(c.String("key"), cfg)
+}
@@ -9,11 +9,34 @@ import (
 	"io"
 
 	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/gcm"
 )
 
+// MaxSenderLength is the longest sender ID that can be sealed into a message, longer ones are truncated
+const MaxSenderLength = 255
+
+// MessageIDSize is the length of the random ID sealed into every packet of a message, which receivers group its shards
+// by. Each packet has its own nonce, so the nonce cannot serve as the message ID
+const MessageIDSize = 8
+
+// PayloadVersion is the version of the layout of the sealed payload of a packet: the version, the message ID, the
+// sender ID length and sender ID, then the data. Payloads of any other version are rejected, so a peer running a
+// different layout fails to open the packets rather than misreading them
+const PayloadVersion byte = 2
+
+// ErrPayloadVersion is returned for a sealed payload of a version other than PayloadVersion
+var ErrPayloadVersion = errors.New("unsupported payload version")
+
+// DecryptMessage deciphers a nonce prefixed message produced by EncryptMessage without its magic, discarding the
+// message and sender IDs
 func DecryptMessage(creator string, ciph cipher.AEAD, data []byte) (msg []byte, err error) {
 	nonceSize := ciph.NonceSize()
-	msg, err = ciph.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if len(data) < nonceSize {
+		return nil, errors.New(creator + " message is shorter than a nonce")
+	}
+	if msg, err = ciph.Open(nil, data[:nonceSize], data[nonceSize:], nil); err == nil {
+		_, _, msg, err = splitPayload(msg)
+	}
 	if err != nil {
 		err = errors.New(fmt.Sprintf("%s %s", creator, err.Error()))
 	} else {
@@ -22,14 +45,25 @@ func DecryptMessage(creator string, ciph cipher.AEAD, data []byte) (msg []byte,
 	return
 }
 
-// EncryptMessage encrypts a message, if the nonce is given it uses that otherwise it generates a new one. If there is
-// no cipher this just returns a message with the given magic prepended.
-func EncryptMessage(creator string, ciph cipher.AEAD, magic []byte, nonce, data []byte) (msg []byte, err error) {
+// EncryptMessage encrypts a message under a new nonce. If the message ID is given it is sealed in with the data,
+// otherwise a new one is generated, all of the shards of a message must be sent with the same one. The creator is
+// sealed in as the sender ID, so receivers can tell apart messages from different senders that share an ID. The sender
+// ID is only as trustworthy as the shared key, anyone holding the key can seal any sender ID. If there is no cipher
+// this just returns a message with the given magic prepended.
+func EncryptMessage(creator string, ciph cipher.AEAD, magic []byte, id, data []byte) (msg []byte, err error) {
 	if ciph != nil {
-		if nonce == nil {
-			nonce, err = GetNonce(ciph)
+		if id == nil {
+			if id, err = NewMessageID(); err != nil {
+				return
+			}
+		}
+		var nonce []byte
+		if nonce, err = GetNonce(ciph); err != nil {
+			return
+		}
+		if msg, err = gcm.Seal(ciph, append(append([]byte{}, magic...), nonce...), nonce,
+			joinPayload(id, creator, data), nil); slog.Check(err) {
 		}
-		msg = append(append(magic, nonce...), ciph.Seal(nil, nonce, data, nil)...)
 	} else {
 		msg = append(magic, data...)
 	}
@@ -37,10 +71,92 @@ func EncryptMessage(creator string, ciph cipher.AEAD, magic []byte, nonce, data
 	return
 }
 
+// PacketOverhead is the most that EncryptMessage adds to the data it seals: the magic, the nonce, the payload header
+// with the longest sender ID and the authentication tag
+func PacketOverhead(ciph cipher.AEAD) int {
+	return 4 + ciph.NonceSize() + 2 + MessageIDSize + MaxSenderLength + ciph.Overhead()
+}
+
+// GetNonce returns a random nonce for the cipher. Every packet must be sealed with its own
 func GetNonce(ciph cipher.AEAD) (nonce []byte, err error) {
-	// get a nonce for the packet, it is both message ID and salt
 	nonce = make([]byte, ciph.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); slog.Check(err) {
 	}
 	return
 }
+
+// NewMessageID returns a random message ID
+func NewMessageID() (id []byte, err error) {
+	id = make([]byte, MessageIDSize)
+	if _, err = io.ReadFull(rand.Reader, id); slog.Check(err) {
+	}
+	return
+}
+
+// OpenPacket splits a packet into its magic and nonce and deciphers the payload, returning the message ID, sender ID
+// and data that were sealed in it. The sender ID only tells apart the holders of the key, it does not authenticate one
+// of them
+func OpenPacket(ciph cipher.AEAD, packet []byte) (magic string, id []byte, sender string, data []byte, err error) {
+	nL := ciph.NonceSize()
+	if len(packet) < 4+nL {
+		err = errors.New("packet is too short")
+		return
+	}
+	magic = string(packet[:4])
+	nonce := packet[4 : 4+nL]
+	var plain []byte
+	if plain, err = ciph.Open(nil, nonce, packet[4+nL:], nil); err != nil {
+		return
+	}
+	id, sender, data, err = splitPayload(plain)
+	return
+}
+
+// joinPayload prefixes data with the payload version, the message ID, and the sender ID and its length
+func joinPayload(id []byte, sender string, data []byte) (out []byte) {
+	if len(sender) > MaxSenderLength {
+		sender = sender[:MaxSenderLength]
+	}
+	const hL = 2 + MessageIDSize
+	out = make([]byte, hL+len(sender)+len(data))
+	out[0] = PayloadVersion
+	copy(out[1:1+MessageIDSize], id)
+	out[1+MessageIDSize] = byte(len(sender))
+	copy(out[hL:], sender)
+	copy(out[hL+len(sender):], data)
+	return
+}
+
+// splitPayload checks the payload version and separates the message and sender IDs from the data they prefix
+func splitPayload(b []byte) (id []byte, sender string, data []byte, err error) {
+	const hL = 2 + MessageIDSize
+	if len(b) < hL {
+		err = errors.New("sealed payload is shorter than its header")
+		return
+	}
+	if b[0] != PayloadVersion {
+		err = fmt.Errorf("%w: %d", ErrPayloadVersion, b[0])
+		return
+	}
+	sL := int(b[hL-1])
+	if len(b) < hL+sL {
+		err = errors.New("sealed payload is shorter than its sender ID")
+		return
+	}
+	id = b[1 : 1+MessageIDSize]
+	sender = string(b[hL : hL+sL])
+	data = b[hL+sL:]
+	return
+}
+
+// authenticate returns the magic of a packet if it opens with the cipher, whether it is a plain or a topic packet
+func authenticate(ciph cipher.AEAD, packet []byte) (magic string, err error) {
+	if len(packet) >= 4 && string(packet[:4]) == string(TopicMagic) {
+		if _, _, _, _, err = OpenTopicPacket(ciph, packet); err == nil {
+			magic = string(TopicMagic)
+		}
+		return
+	}
+	magic, _, _, _, err = OpenPacket(ciph, packet)
+	return
+}
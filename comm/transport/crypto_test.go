@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+func TestPayloadVersion(t *testing.T) {
+	ciph, err := gcm.GetCipher("payload test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := EncryptMessage("sender", ciph, []byte("test"), nil, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, sender, data, err := OpenPacket(ciph, packet); err != nil || sender != "sender" || string(data) != "data" {
+		t.Fatal("packet did not open", sender, data, err)
+	}
+	// a payload of an earlier layout, as sealed by an older peer, is rejected instead of being misread
+	nonce := packet[4 : 4+ciph.NonceSize()]
+	old := append(append([]byte("test"), nonce...), ciph.Seal(nil, nonce, []byte("\x01\x06senderdata"), nil)...)
+	if _, _, _, _, err = OpenPacket(ciph, old); !errors.Is(err, ErrPayloadVersion) {
+		t.Fatal("expected ErrPayloadVersion, got", err)
+	}
+}
+
+func TestPacketOverhead(t *testing.T) {
+	for _, suite := range gcm.Suites {
+		ciph, err := gcm.GetSuiteCipher("overhead test", gcm.Config{Suite: suite, Params: gcm.LowMemoryParams})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 100)
+		packet, err := EncryptMessage(string(make([]byte, MaxSenderLength+10)), ciph, []byte("test"), nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packet) != len(data)+PacketOverhead(ciph) {
+			t.Fatal(suite, "packet is", len(packet), "bytes, expected", len(data)+PacketOverhead(ciph))
+		}
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	ciph, err := gcm.GetCipher("payload test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the shards of a message share its ID but never a nonce
+	nonces := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		packet, err := EncryptMessage("sender", ciph, []byte("test"), id, []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := string(packet[4 : 4+ciph.NonceSize()])
+		if nonces[nonce] {
+			t.Fatal("two shards of a message were sealed under the same nonce")
+		}
+		nonces[nonce] = true
+		_, got, _, _, err := OpenPacket(ciph, packet)
+		if err != nil || !bytes.Equal(got, id) {
+			t.Fatal("message ID did not survive sealing", got, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"hash/fnv"
+	"net"
+
+	"go.uber.org/atomic"
+
+	"github.com/p9c/pkg/app/slog"
+)
+
+// BackpressurePolicy determines what a Dispatcher does with a new message when its queue is full
+type BackpressurePolicy int
+
+const (
+	// Block makes the receiving goroutine wait until there is space in the queue
+	Block BackpressurePolicy = iota
+	// DropNewest discards the message that just arrived
+	DropNewest
+	// DropOldest discards the message at the head of the queue to make room for the one that just arrived
+	DropOldest
+)
+
+const (
+	DefaultDispatchQueueSize = 64
+	DefaultDispatchWorkers   = 4
+)
+
+// DispatchConfig sets the parameters of the dispatch stage for a magic. Zero values select the defaults
+type DispatchConfig struct {
+	// QueueSize is the maximum number of messages waiting to be handled
+	QueueSize int
+	// Workers is the number of goroutines invoking the handler
+	Workers int
+	// Ordered guarantees that messages from one source address are handled in the order they were received, by
+	// always assigning a source to the same worker
+	Ordered bool
+	// Policy is what to do when the queue is full
+	Policy BackpressurePolicy
+}
+
+// DispatchMetrics are counters describing the traffic through a Dispatcher
+type DispatchMetrics struct {
+	// Queued counts the messages that entered the queue, including those DropOldest later discarded, but not those
+	// DropNewest turned away
+	Queued atomic.Uint64
+	// Dispatched counts the messages handed to the handler
+	Dispatched atomic.Uint64
+	// Dropped counts the messages discarded by the policy
+	Dropped atomic.Uint64
+	// Blocked counts the times Handle waited for room in the queue
+	Blocked atomic.Uint64
+	// Errors counts the messages the handler returned an error for
+	Errors atomic.Uint64
+}
+
+type dispatchJob struct {
+	ctx interface{}
+	src net.Addr
+	dst string
+	b   []byte
+}
+
+// Dispatcher moves the invocation of a HandlerFunc off the goroutine reading the socket onto a pool of workers fed by
+// a bounded queue, so a slow handler does not stall reception
+type Dispatcher struct {
+	DispatchConfig
+	Metrics DispatchMetrics
+	handler HandlerFunc
+	// lanes has a queue per worker when the dispatcher is ordered, otherwise one queue shared by all workers
+	lanes []chan dispatchJob
+	quit  chan struct{}
+}
+
+// NewDispatcher starts the workers for a handler, they run until the quit channel is closed
+func NewDispatcher(handler HandlerFunc, cfg DispatchConfig, quit chan struct{}) (d *Dispatcher) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultDispatchQueueSize
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultDispatchWorkers
+	}
+	d = &Dispatcher{
+		DispatchConfig: cfg,
+		handler:        handler,
+		quit:           quit,
+	}
+	if cfg.Ordered {
+		// the queue is divided between the lanes so the total bound stays the same
+		laneSize := cfg.QueueSize / cfg.Workers
+		if laneSize < 1 {
+			laneSize = 1
+		}
+		d.lanes = make([]chan dispatchJob, cfg.Workers)
+		for i := range d.lanes {
+			d.lanes[i] = make(chan dispatchJob, laneSize)
+		}
+	} else {
+		d.lanes = []chan dispatchJob{make(chan dispatchJob, cfg.QueueSize)}
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go d.worker(d.lanes[i%len(d.lanes)])
+	}
+	return
+}
+
+// Dispatch returns a copy of the handlers with each one wrapped in a Dispatcher using the same configuration, and the
+// dispatchers keyed by magic so their metrics can be read
+func (h Handlers) Dispatch(cfg DispatchConfig, quit chan struct{}) (out Handlers, dispatchers map[string]*Dispatcher) {
+	out = make(Handlers, len(h))
+	dispatchers = make(map[string]*Dispatcher, len(h))
+	for magic := range h {
+		d := NewDispatcher(h[magic], cfg, quit)
+		dispatchers[magic] = d
+		out[magic] = d.Handle
+	}
+	return
+}
+
+// Handle is a HandlerFunc that queues the message for a worker and returns immediately, unless the policy is Block
+// and the queue is full
+func (d *Dispatcher) Handle(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	job := dispatchJob{ctx: ctx, src: src, dst: dst, b: b}
+	lane := d.lane(src)
+	select {
+	case lane <- job:
+		d.Metrics.Queued.Inc()
+		return
+	default:
+	}
+	switch d.Policy {
+	case DropNewest:
+		d.Metrics.Dropped.Inc()
+		slog.Debug("dispatch queue full, dropping newest message from", src)
+	case DropOldest:
+		for {
+			select {
+			case lane <- job:
+				d.Metrics.Queued.Inc()
+				return
+			default:
+			}
+			select {
+			case <-lane:
+				d.Metrics.Dropped.Inc()
+				slog.Debug("dispatch queue full, dropping oldest message")
+			default:
+			}
+		}
+	default:
+		d.Metrics.Blocked.Inc()
+		select {
+		case lane <- job:
+			d.Metrics.Queued.Inc()
+		case <-d.quit:
+		}
+	}
+	return
+}
+
+// Len returns the number of messages waiting in the queue
+func (d *Dispatcher) Len() (n int) {
+	for i := range d.lanes {
+		n += len(d.lanes[i])
+	}
+	return
+}
+
+func (d *Dispatcher) lane(src net.Addr) chan dispatchJob {
+	if len(d.lanes) == 1 || src == nil {
+		return d.lanes[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(src.String()))
+	return d.lanes[h.Sum32()%uint32(len(d.lanes))]
+}
+
+func (d *Dispatcher) worker(lane chan dispatchJob) {
+out:
+	for {
+		select {
+		case <-d.quit:
+			break out
+		case job := <-lane:
+			if err := d.handler(job.ctx, job.src, job.dst, job.b); slog.Check(err) {
+				d.Metrics.Errors.Inc()
+			}
+			d.Metrics.Dispatched.Inc()
+		}
+	}
+}
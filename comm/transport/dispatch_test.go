@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOrdered(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	var mx sync.Mutex
+	got := make(map[string][]byte)
+	var wg sync.WaitGroup
+	handler := func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+		mx.Lock()
+		got[src.String()] = append(got[src.String()], b[0])
+		mx.Unlock()
+		wg.Done()
+		return
+	}
+	d := NewDispatcher(handler, DispatchConfig{QueueSize: 256, Workers: 4, Ordered: true}, quit)
+	srcs := []net.Addr{
+		&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1},
+		&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1},
+		&net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1},
+	}
+	for i := 0; i < 50; i++ {
+		for j := range srcs {
+			wg.Add(1)
+			if err := d.Handle(nil, srcs[j], "", []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	for i := range srcs {
+		seq := got[srcs[i].String()]
+		if len(seq) != 50 {
+			t.Fatal("expected 50 messages from", srcs[i], "got", len(seq))
+		}
+		for j := range seq {
+			if seq[j] != byte(j) {
+				t.Fatal("messages from", srcs[i], "were handled out of order")
+			}
+		}
+	}
+	if d.Metrics.Dispatched.Load() != 150 {
+		t.Fatal("expected 150 dispatched, got", d.Metrics.Dispatched.Load())
+	}
+}
+
+func TestDispatcherDropPolicies(t *testing.T) {
+	for _, policy := range []BackpressurePolicy{DropNewest, DropOldest} {
+		quit := make(chan struct{})
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		var mx sync.Mutex
+		var handled []byte
+		handler := func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+			mx.Lock()
+			handled = append(handled, b[0])
+			mx.Unlock()
+			return
+		}
+		d := NewDispatcher(handler, DispatchConfig{QueueSize: 2, Workers: 1, Policy: policy}, quit)
+		// the first message occupies the worker, the next two fill the queue and the last two overflow it
+		_ = d.Handle(nil, nil, "", []byte{0})
+		<-started
+		for i := 1; i < 5; i++ {
+			_ = d.Handle(nil, nil, "", []byte{byte(i)})
+		}
+		if d.Metrics.Dropped.Load() != 2 {
+			t.Fatal("expected 2 dropped, got", d.Metrics.Dropped.Load())
+		}
+		// DropNewest turns the last two away, DropOldest queues them in place of the two it discards
+		queued := uint64(3)
+		if policy == DropOldest {
+			queued = 5
+		}
+		if d.Metrics.Queued.Load() != queued {
+			t.Fatal("policy", policy, "expected", queued, "queued, got", d.Metrics.Queued.Load())
+		}
+		close(release)
+		deadline := time.Now().Add(time.Second)
+		for d.Metrics.Dispatched.Load() < 3 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		mx.Lock()
+		expected := []byte{0, 1, 2}
+		if policy == DropOldest {
+			expected = []byte{0, 3, 4}
+		}
+		if string(handled) != string(expected) {
+			t.Fatal("policy", policy, "expected", expected, "got", handled)
+		}
+		mx.Unlock()
+		close(quit)
+	}
+}
+
+func TestChannelDispatches(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	release := make(chan struct{})
+	received := make(chan []byte, 2)
+	handlers := Handlers{
+		"test": func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+			if b[0] == 0 {
+				<-release
+			}
+			received <- b
+			return
+		},
+	}
+	addr := "127.0.0.1:11880"
+	channel, err := NewChannel(ChannelConfig{Network: UDP, Creator: "dispatch", Key: "dispatch test", Listen: addr,
+		Send: addr, Dispatch: DispatchConfig{Workers: 2}}, nil, handlers, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first message holds a worker, the second must still be handled
+	for i := byte(0); i < 2; i++ {
+		if err = channel.SendMessage([]byte("test"), []byte{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(t, received, []byte{1})
+	close(release)
+	expect(t, received, []byte{0})
+}
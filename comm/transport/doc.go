@@ -1,3 +1,7 @@
 // Package transport provides a listener and sender channel for unicast and multicast UDP IPv4 short message chat
-// protocol with a pre shared key, forward error correction facilities with a nice friendly declaration syntax
+// protocol with a pre shared key, forward error correction facilities with a nice friendly declaration syntax. Where
+// UDP is not available the same messages and Handlers can be carried over TCP or Unix domain sockets, see NewChannel.
+//
+// The forward error correction codec is chosen from those registered in package coding by ChannelConfig.Codec or
+// Channel.SetCodec, and coding.Select times them on a typical message to find the fastest
 package transport
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding"
+	// registers the segmented fec codec so it can be configured by name
+	_ "github.com/p9c/pkg/coding/fec"
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// MessageChannel is the interface common to the channel implementations, so that code written against Handlers can
+// change the transport it runs on by configuration
+type MessageChannel interface {
+	// SendMessage sends a message with a magic the receiving Handlers dispatch on
+	SendMessage(magic, data []byte) (err error)
+	// Close shuts the channel down
+	Close() (err error)
+}
+
+// The networks a channel can be created on with NewChannel
+const (
+	Multicast = "multicast"
+	UDP       = "udp"
+	TCP       = "tcp"
+	Unix      = "unix"
+)
+
+// ChannelConfig selects and configures a channel implementation
+type ChannelConfig struct {
+	// Network is one of Multicast (the default), UDP, TCP or Unix
+	Network string
+	// Creator is the sender ID sealed in to the messages sent
+	Creator string
+	// Key is the pre shared key
+	Key string
+	// Port is the port of a Multicast channel
+	Port int
+	// Listen is the address a UDP, TCP or Unix channel receives on
+	Listen string
+	// Send is the address a UDP channel sends to, or a TCP or Unix channel dials
+	Send string
+	// MaxDatagramSize is the size of the receive buffer for Multicast and UDP channels
+	MaxDatagramSize int
+	// Codec is the name of the registered coding.Codec Multicast and UDP channels split messages into shards with,
+	// DefaultCodec if it is empty. Stream channels send whole messages and don't use one
+	Codec string
+	// Cipher selects the cipher suite, key derivation parameters and salt the channel's cipher is derived from Key
+	// with. The zero value is the AES-GCM cipher of gcm.GetCipher. Every party to the channel must configure the same
+	Cipher gcm.Config
+	// Dispatch configures the worker pool the handlers are invoked on, so that a slow handler does not stall reception.
+	// The zero value is the defaults of NewDispatcher
+	Dispatch DispatchConfig
+	// Inline invokes the handlers on the goroutine reading the socket instead of dispatching them, for handlers that
+	// are quick and rely on being called one at a time
+	Inline bool
+}
+
+// NewChannel creates a channel on the configured network. Unless cfg.Inline is set the handlers are wrapped in
+// Dispatchers that run until quit is closed
+func NewChannel(cfg ChannelConfig, ctx interface{}, handlers Handlers, quit chan struct{}) (
+	channel MessageChannel, err error) {
+	if cfg.MaxDatagramSize <= 0 {
+		cfg.MaxDatagramSize = 8192
+	}
+	codec := DefaultCodec
+	if cfg.Codec != "" {
+		if codec, err = coding.GetCodec(cfg.Codec); err != nil {
+			return
+		}
+	}
+	var ciph cipher.AEAD
+	var tagKey []byte
+	if ciph, tagKey, err = channelKeys(cfg.Key, cfg.Cipher); slog.Check(err) {
+		return
+	}
+	if !cfg.Inline {
+		handlers, _ = handlers.Dispatch(cfg.Dispatch, quit)
+	}
+	switch cfg.Network {
+	case Multicast, "":
+		if cfg.Port == 0 {
+			cfg.Port = DefaultPort
+		}
+		var c *Channel
+		if c, err = newBroadcastChannel(cfg.Creator, ctx, ciph, tagKey, cfg.Port, cfg.MaxDatagramSize, handlers,
+			quit); err == nil {
+			c.SetCodec(codec)
+			channel = c
+		}
+	case UDP:
+		var c *Channel
+		if c, err = newUnicastChannel(cfg.Creator, ctx, ciph, tagKey, cfg.Send, cfg.Listen, cfg.MaxDatagramSize,
+			handlers, quit); err == nil {
+			c.SetCodec(codec)
+			channel = c
+		}
+	case TCP, Unix:
+		var c *StreamChannel
+		if c, err = newStreamChannel(cfg.Creator, ctx, ciph, cfg.Network, cfg.Listen, cfg.Send, handlers,
+			quit); err == nil {
+			channel = c
+		}
+	default:
+		err = errors.New(fmt.Sprint("unknown channel network ", cfg.Network))
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+func TestChannelSuites(t *testing.T) {
+	for i, suite := range gcm.Suites {
+		quit := make(chan struct{})
+		received := make(chan []byte, 1)
+		handlers := Handlers{
+			"test": func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+				received <- b
+				return
+			},
+		}
+		// the channel sends to its own listener
+		addr := fmt.Sprint("127.0.0.1:", 11876+i)
+		channel, err := NewChannel(ChannelConfig{Network: UDP, Creator: "suite", Key: "suite test", Listen: addr, MaxDatagramSize: 1 << 20,
+			Send: addr, Cipher: gcm.Config{Suite: suite, Params: gcm.LowMemoryParams, Salt: gcm.SaltFromID("test")}},
+			nil, handlers, quit)
+		if err != nil {
+			t.Fatal(suite, err)
+		}
+		msg := make([]byte, 20000)
+		for j := range msg {
+			msg[j] = byte(j)
+		}
+		if err = channel.SendMessage([]byte("test"), msg); err != nil {
+			t.Fatal(suite, err)
+		}
+		expect(t, received, msg)
+		close(quit)
+	}
+	if _, err := NewChannel(ChannelConfig{Network: UDP, Key: "suite test", Cipher: gcm.Config{Suite: "rot13"}}, nil,
+		Handlers{}, nil); err == nil {
+		t.Fatal("expected an error for an unknown suite")
+	}
+}
+
+func TestChannelLimits(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	rekey := make(chan gcm.Usage, 1)
+	received := make(chan []byte, 100)
+	handlers := Handlers{
+		"test": func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+			received <- b
+			return
+		},
+	}
+	addr := "127.0.0.1:11879"
+	mc, err := NewChannel(ChannelConfig{Network: UDP, Creator: "limits", Key: "limits test", Listen: addr,
+		Send: addr, Cipher: gcm.Config{Params: gcm.LowMemoryParams, Limits: gcm.Limits{Seals: 40},
+			OnRekey: func(u gcm.Usage) { rekey <- u }}}, nil, handlers, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := mc.(*Channel)
+	var sent int
+	for ; sent < 100; sent++ {
+		if err = channel.SendMessage([]byte("test"), []byte("message")); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, gcm.ErrLimitReached) || sent == 0 {
+		t.Fatal("expected sending to stop at the limit, sent", sent, err)
+	}
+	select {
+	case u := <-rekey:
+		if u.Seals != 30 {
+			t.Fatal("rekey event at", u.Seals, "seals")
+		}
+	default:
+		t.Fatal("no rekey event")
+	}
+	// once the cipher is replaced sending resumes
+	ciph, err := gcm.GetSuiteCipher("limits test rekeyed", gcm.Config{Params: gcm.LowMemoryParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel.SetCipher(ciph)
+	// messages sent before the limit may still be arriving, and the small receive buffer can drop a burst, so the
+	// message is repeated until it is received
+	timeout := time.After(5 * time.Second)
+	for {
+		if err = channel.SendMessage([]byte("test"), []byte("rekeyed")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case b := <-received:
+			if string(b) == "rekeyed" {
+				return
+			}
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("message sent with the new cipher was not received")
+		}
+	}
+}
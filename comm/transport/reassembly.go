@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/p9c/pkg/coding"
+)
+
+const (
+	// DefaultReassemblyTimeout is how long shards of a message are kept waiting for the rest, and how long a decoded
+	// message is remembered so its late shards are ignored
+	DefaultReassemblyTimeout = 3 * time.Second
+	// DefaultMaxMessages is the maximum number of messages tracked at once before the oldest are evicted
+	DefaultMaxMessages = 1024
+)
+
+// reassembler collects the shards of many concurrently arriving messages and decodes each one independently once
+// enough of its shards have arrived. Entries are only removed when they age out or the table is full, a decoded
+// message drops its shards but is remembered so that its remaining shards are not taken for a new message
+type reassembler struct {
+	buffers     map[string]*MsgBuffer
+	timeout     time.Duration
+	maxMessages int
+	lastEvict   time.Time
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		buffers:     make(map[string]*MsgBuffer),
+		timeout:     DefaultReassemblyTimeout,
+		maxMessages: DefaultMaxMessages,
+	}
+}
+
+// messageKey identifies the message a shard belongs to. Shards are only ever combined if they were sealed by the same
+// sender with the same message ID, and unless multiPath is set, also arrived from the same source address, so shards
+// from a different sender with a colliding or forged message ID cannot be mixed into a message
+func messageKey(src, sender, id string, multiPath bool) (key string) {
+	key = string([]byte{byte(len(sender))}) + sender + id
+	if !multiPath {
+		key = src + "/" + key
+	}
+	return
+}
+
+// Add stores a shard of the message with the given key, and if this completes the message, returns it decoded with the
+// codec. Decoding is only attempted once the codec's minimum number of shards for the message have arrived. Shards for
+// messages that are already decoded are discarded
+func (r *reassembler) Add(key string, src net.Addr, shard []byte, codec coding.Codec) (msg []byte, err error) {
+	now := time.Now()
+	r.evict(now)
+	bn, ok := r.buffers[key]
+	if !ok {
+		r.makeRoom()
+		bn = &MsgBuffer{First: now, Source: src}
+		r.buffers[key] = bn
+	}
+	if bn.Decoded || len(shard) < 1 {
+		return
+	}
+	var id int
+	if id, err = codec.ShardID(shard); err != nil {
+		return
+	}
+	// a duplicate, as arrives by multiple paths, adds nothing
+	for i := range bn.ids {
+		if bn.ids[i] == id {
+			return
+		}
+	}
+	if bn.min == 0 {
+		if bn.min, err = codec.MinShards(shard); err != nil {
+			return
+		}
+	}
+	bn.Buffers = append(bn.Buffers, shard)
+	bn.ids = append(bn.ids, id)
+	if len(bn.Buffers) < bn.min {
+		return
+	}
+	if msg, err = codec.Decode(bn.Buffers); err != nil {
+		msg = nil
+		if errors.Is(err, coding.ErrInsufficientShards) {
+			// more shards may yet make it decodable
+			err = nil
+		} else {
+			// the shard just added does not fit with the ones before it
+			bn.Buffers = bn.Buffers[:len(bn.Buffers)-1]
+			bn.ids = bn.ids[:len(bn.ids)-1]
+		}
+		return
+	}
+	bn.Decoded = true
+	bn.Buffers = nil
+	bn.ids = nil
+	return
+}
+
+// Len returns the number of messages being tracked
+func (r *reassembler) Len() int {
+	return len(r.buffers)
+}
+
+// evict removes the messages older than the timeout, checking at most four times per timeout period
+func (r *reassembler) evict(now time.Time) {
+	if now.Sub(r.lastEvict) < r.timeout/4 {
+		return
+	}
+	r.lastEvict = now
+	for i := range r.buffers {
+		if now.Sub(r.buffers[i].First) > r.timeout {
+			delete(r.buffers, i)
+		}
+	}
+}
+
+// makeRoom removes the oldest messages until there is space for a new one
+func (r *reassembler) makeRoom() {
+	for r.maxMessages > 0 && len(r.buffers) >= r.maxMessages {
+		var oldest string
+		var oldestTime time.Time
+		for i := range r.buffers {
+			if oldestTime.IsZero() || r.buffers[i].First.Before(oldestTime) {
+				oldest, oldestTime = i, r.buffers[i].First
+			}
+		}
+		delete(r.buffers, oldest)
+	}
+}
@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p9c/pkg/coding"
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+func TestReassemblerInterleaved(t *testing.T) {
+	r := newReassembler()
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	messages := [][]byte{
+		[]byte("first message in flight"),
+		[]byte("second message in flight"),
+		[]byte("third message in flight"),
+	}
+	var shards [][][]byte
+	for i := range messages {
+		shards = append(shards, mustShards(t, messages[i]))
+	}
+	decoded := make(map[int][]byte)
+	// deliver the shards of all three messages interleaved and in reverse order
+	for j := len(shards[0]) - 1; j >= 0; j-- {
+		for i := range shards {
+			msg, err := r.Add(string(rune('a'+i)), src, shards[i][j], DefaultCodec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg != nil {
+				if _, ok := decoded[i]; ok {
+					t.Fatal("message", i, "was decoded twice")
+				}
+				decoded[i] = msg
+			}
+		}
+	}
+	for i := range messages {
+		if !bytes.Equal(decoded[i], messages[i]) {
+			t.Fatal("message", i, "was not reassembled")
+		}
+	}
+	if r.Len() != len(messages) {
+		t.Fatal("decoded messages should be remembered until they age out")
+	}
+}
+
+func TestReassemblerEviction(t *testing.T) {
+	r := newReassembler()
+	r.timeout = time.Millisecond * 20
+	r.maxMessages = 2
+	shards := mustShards(t, []byte("evicted"))
+	for _, nonce := range []string{"a", "b", "c"} {
+		if _, err := r.Add(nonce, nil, shards[0], DefaultCodec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := r.buffers["a"]; ok || r.Len() != 2 {
+		t.Fatal("the oldest message should have been evicted to make room")
+	}
+	time.Sleep(r.timeout * 2)
+	if _, err := r.Add("d", nil, shards[0], DefaultCodec); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 1 {
+		t.Fatal("messages older than the timeout should have been evicted, have", r.Len())
+	}
+}
+
+func TestReassemblerSourceBound(t *testing.T) {
+	ciph, err := gcm.GetCipher("source bound test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgID, err := NewMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type sender struct {
+		id, src string
+		msg     []byte
+	}
+	// three senders using the same message ID, two of them with the same ID behind different addresses
+	senders := []sender{
+		{"alice", "10.0.0.1:11049", []byte("message from alice")},
+		{"bob", "10.0.0.1:11049", []byte("a different message from bob")},
+		{"alice", "10.0.0.2:11049", []byte("message from ALICE")},
+	}
+	for _, multiPath := range []bool{false, true} {
+		r := newReassembler()
+		var shards [][][]byte
+		for i := range senders {
+			shards = append(shards, mustShards(t, senders[i].msg))
+		}
+		decoded := make(map[int][]byte)
+		for j := range shards[0] {
+			for i := range senders {
+				var packet []byte
+				if packet, err = EncryptMessage(senders[i].id, ciph, []byte("test"), msgID,
+					shards[i][j]); err != nil {
+					t.Fatal(err)
+				}
+				_, n, id, shard, err := OpenPacket(ciph, packet)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id != senders[i].id {
+					t.Fatal("sender ID", id, "did not survive sealing, expected", senders[i].id)
+				}
+				msg, err := r.Add(messageKey(senders[i].src, id, string(n), multiPath), nil, shard, DefaultCodec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg != nil {
+					decoded[i] = msg
+				}
+			}
+		}
+		if !bytes.Equal(decoded[1], senders[1].msg) {
+			t.Fatal("shards of different senders were mixed, multi path", multiPath)
+		}
+		if multiPath {
+			// the two alices are treated as one sender reachable on two paths, so only one of them gets decoded
+			if len(decoded) != 2 {
+				t.Fatal("expected two messages with multi path, got", len(decoded))
+			}
+		} else if !bytes.Equal(decoded[0], senders[0].msg) || !bytes.Equal(decoded[2], senders[2].msg) {
+			t.Fatal("shards of the same sender ID from different sources were mixed")
+		}
+	}
+}
+
+func mustShards(t *testing.T, b []byte) (shards [][]byte) {
+	var err error
+	if shards, err = GetShards(b); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestReassemblerCodecs(t *testing.T) {
+	message := make([]byte, 40000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	for _, name := range coding.Codecs() {
+		codec, err := coding.GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards, err := codec.Encode(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := newReassembler()
+		var decoded []byte
+		// the first shard is lost and the rest arrive in reverse, each one twice
+		for j := len(shards) - 1; j > 0; j-- {
+			for k := 0; k < 2; k++ {
+				msg, err := r.Add("a", nil, shards[j], codec)
+				if err != nil {
+					t.Fatal(name, err)
+				}
+				if msg != nil {
+					if decoded != nil {
+						t.Fatal(name, "message was decoded twice")
+					}
+					decoded = msg
+				}
+			}
+		}
+		if !bytes.Equal(decoded, message) {
+			t.Fatal(name, "message was not reassembled")
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// DefaultMaxFrameSize is the largest frame a StreamChannel accepts, larger frames close the connection
+const DefaultMaxFrameSize = 1 << 24
+
+// StreamChannel carries the same encrypted, magic dispatched messages as a Channel over TCP or Unix domain socket
+// connections, for networks where UDP multicast is blocked. Each message is sent whole in one frame, a 4 byte length
+// followed by the magic, nonce and sealed message ID, sender ID and data, as there is no packet loss to correct for.
+//
+// A StreamChannel can listen, dial, or both. Messages are sent to every connected peer, so a listening channel with
+// several peers dialed in to it behaves like a broadcast channel for the messages it sends
+type StreamChannel struct {
+	sync.Mutex
+	Creator      string
+	Network      string
+	MaxFrameSize int
+	context      interface{}
+	ciph         cipher.AEAD
+	handlers     Handlers
+	listener     net.Listener
+	dial         string
+	conns        map[net.Conn]*sync.Mutex
+	quit         chan struct{}
+}
+
+// NewStreamChannel creates a channel on the network "tcp" or "unix", listening on the listen address and connecting to
+// the dial address, either of which may be empty
+func NewStreamChannel(creator string, ctx interface{}, key, network, listen, dial string, handlers Handlers,
+	quit chan struct{}) (channel *StreamChannel, err error) {
+	var ciph cipher.AEAD
+	if ciph, err = gcm.GetCipher(key); slog.Check(err) {
+		return
+	}
+	return newStreamChannel(creator, ctx, ciph, network, listen, dial, handlers, quit)
+}
+
+// newStreamChannel creates a stream channel with the given cipher
+func newStreamChannel(creator string, ctx interface{}, ciph cipher.AEAD, network, listen, dial string,
+	handlers Handlers, quit chan struct{}) (channel *StreamChannel, err error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		err = errors.New(fmt.Sprint("unsupported stream network ", network))
+		return
+	}
+	channel = &StreamChannel{
+		Creator:      creator,
+		Network:      network,
+		MaxFrameSize: DefaultMaxFrameSize,
+		context:      ctx,
+		ciph:         ciph,
+		handlers:     handlers,
+		dial:         dial,
+		conns:        make(map[net.Conn]*sync.Mutex),
+		quit:         quit,
+	}
+	if listen != "" {
+		if channel.listener, err = net.Listen(network, listen); slog.Check(err) {
+			return
+		}
+		go channel.accept()
+	}
+	if dial != "" {
+		if err = channel.connect(); slog.Check(err) {
+			// the peer may not be up yet, sending will retry
+			err = nil
+		}
+	}
+	go func() {
+		<-quit
+		if err := channel.Close(); slog.Check(err) {
+		}
+	}()
+	slog.Debug("started stream channel", creator, network, listen, dial)
+	return
+}
+
+// Addr returns the address the channel is listening on, or nil if it is not listening
+func (c *StreamChannel) Addr() net.Addr {
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Addr()
+}
+
+// Peers returns the number of open connections
+func (c *StreamChannel) Peers() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.conns)
+}
+
+// SendMessage seals a message and writes it to every connected peer, dialing the configured address first if there
+// are no connections
+func (c *StreamChannel) SendMessage(magic, data []byte) (err error) {
+	if len(magic) != 4 {
+		return errors.New("magic must be 4 bytes long")
+	}
+	if len(data) == 0 {
+		return errors.New("not sending empty message")
+	}
+	if c.Peers() == 0 && c.dial != "" {
+		if err = c.connect(); slog.Check(err) {
+			return
+		}
+	}
+	var msg []byte
+	if msg, err = EncryptMessage(c.Creator, c.ciph, append([]byte{}, magic...), nil, data); slog.Check(err) {
+		return
+	}
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	c.Lock()
+	conns := make(map[net.Conn]*sync.Mutex, len(c.conns))
+	for conn, mx := range c.conns {
+		conns[conn] = mx
+	}
+	c.Unlock()
+	if len(conns) == 0 {
+		return errors.New("no peers to send to")
+	}
+	for conn, mx := range conns {
+		mx.Lock()
+		_, werr := conn.Write(frame)
+		mx.Unlock()
+		if werr != nil {
+			slog.Debug("dropping peer", conn.RemoteAddr(), werr)
+			c.drop(conn)
+			err = werr
+		}
+	}
+	return
+}
+
+// Close stops listening and closes all connections
+func (c *StreamChannel) Close() (err error) {
+	if c.listener != nil {
+		if err = c.listener.Close(); err != nil && !isClosedError(err) {
+			slog.Error(err)
+		}
+		err = nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	for conn := range c.conns {
+		if cerr := conn.Close(); cerr != nil && !isClosedError(cerr) {
+			err = cerr
+		}
+		delete(c.conns, conn)
+	}
+	return
+}
+
+func (c *StreamChannel) connect() (err error) {
+	var conn net.Conn
+	if conn, err = net.Dial(c.Network, c.dial); err != nil {
+		return
+	}
+	c.add(conn)
+	return
+}
+
+func (c *StreamChannel) accept() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if isClosedError(err) {
+				return
+			}
+			slog.Error(err)
+			continue
+		}
+		c.add(conn)
+	}
+}
+
+func (c *StreamChannel) add(conn net.Conn) {
+	c.Lock()
+	c.conns[conn] = &sync.Mutex{}
+	c.Unlock()
+	go c.read(conn)
+}
+
+func (c *StreamChannel) drop(conn net.Conn) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.conns[conn]; ok {
+		if err := conn.Close(); err != nil && !isClosedError(err) {
+			slog.Debug(err)
+		}
+		delete(c.conns, conn)
+	}
+}
+
+// read receives frames from a connection and invokes the handler matching their magic until the connection fails
+func (c *StreamChannel) read(conn net.Conn) {
+	defer c.drop(conn)
+	r := bufio.NewReader(conn)
+	src := conn.RemoteAddr()
+	dst := conn.LocalAddr().String()
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF && !isClosedError(err) {
+				slog.Debug("stream read failed", src, err)
+			}
+			return
+		}
+		size := int(binary.BigEndian.Uint32(header))
+		if size > c.MaxFrameSize {
+			slog.Warn("frame of", size, "bytes from", src, "exceeds maximum, closing connection")
+			return
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			slog.Debug("stream read failed", src, err)
+			return
+		}
+		magic, _, _, data, err := OpenPacket(c.ciph, frame)
+		if err != nil {
+			continue
+		}
+		if handler, ok := c.handlers[magic]; ok {
+			if err = handler(c.context, src, dst, data); slog.Check(err) {
+			}
+		}
+	}
+}
+
+func isClosedError(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStreamChannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, network := range []string{TCP, Unix} {
+		listen := "127.0.0.1:0"
+		if network == Unix {
+			listen = filepath.Join(dir, "stream.sock")
+		}
+		quit := make(chan struct{})
+		received := make(chan []byte, 2)
+		handlers := Handlers{
+			"test": func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+				received <- b
+				return
+			},
+		}
+		server, err := NewStreamChannel("server", nil, "stream test", network, listen, "", handlers, quit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var client MessageChannel
+		if client, err = NewChannel(ChannelConfig{Network: network, Creator: "client", Key: "stream test",
+			Send: server.Addr().String()}, nil, handlers, quit); err != nil {
+			t.Fatal(err)
+		}
+		big := make([]byte, 100000)
+		for i := range big {
+			big[i] = byte(i)
+		}
+		if err = client.SendMessage([]byte("test"), big); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, received, big)
+		if err = server.SendMessage([]byte("test"), []byte("reply")); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, received, []byte("reply"))
+		close(quit)
+	}
+}
+
+func expect(t *testing.T, received chan []byte, msg []byte) {
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, msg) {
+			t.Fatal("received message differs from the one sent")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("message was not received")
+	}
+}
@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/atomic"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/p9c/pkg/app/slog"
+	"github.com/p9c/pkg/coding/gcm"
+)
+
+// TopicMagic marks a packet carrying a shard of a topic message. Its header is the magic, the topic tag and the nonce,
+// so receivers can discard the topics they are not subscribed to without deciphering them
+var TopicMagic = []byte{'t', 'o', 'p', 'c'}
+
+// TopicTagSize is the length of the keyed topic tag
+const TopicTagSize = 8
+
+// MaxTopicLength is the longest topic name
+const MaxTopicLength = 255
+
+// Topics holds the subscriptions of a channel
+type Topics struct {
+	sync.RWMutex
+	key      []byte
+	handlers map[string]HandlerFunc
+	tags     map[string]string
+	// Filtered is the number of packets discarded without deciphering because their topic was not subscribed
+	Filtered atomic.Uint64
+}
+
+func newTopics() *Topics {
+	return &Topics{
+		handlers: make(map[string]HandlerFunc),
+		tags:     make(map[string]string),
+	}
+}
+
+// topicKey derives the key topic tags are made with from the key the channel cipher was derived from the pre shared key
+// with, so that it is as hard to recover from the tags as the cipher's own key is, rather than giving a cheap way to
+// test guesses of the pre shared key
+func topicKey(key []byte) (tk []byte) {
+	tk = make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("transport topic key")), tk); slog.Check(err) {
+	}
+	return
+}
+
+// channelKeys derives the cipher of a channel and the key its topic tags are made with from the pre shared key
+func channelKeys(password string, cfg gcm.Config) (ciph cipher.AEAD, tagKey []byte, err error) {
+	var key []byte
+	if key, err = gcm.DeriveSuiteKey(password, cfg); slog.Check(err) {
+		return
+	}
+	if ciph, err = gcm.NewSuiteCipher(key, cfg); slog.Check(err) {
+		return
+	}
+	tagKey = topicKey(key)
+	return
+}
+
+// Tag returns the keyed tag of a topic
+func (t *Topics) Tag(topic string) []byte {
+	h := hmac.New(sha256.New, t.key)
+	_, _ = h.Write([]byte(topic))
+	return h.Sum(nil)[:TopicTagSize]
+}
+
+// Subscribe sets the handler for messages published on a topic
+func (t *Topics) Subscribe(topic string, handler HandlerFunc) {
+	tag := string(t.Tag(topic))
+	t.Lock()
+	defer t.Unlock()
+	t.handlers[topic] = handler
+	t.tags[tag] = topic
+}
+
+// Unsubscribe removes the handler for a topic
+func (t *Topics) Unsubscribe(topic string) {
+	tag := string(t.Tag(topic))
+	t.Lock()
+	defer t.Unlock()
+	delete(t.handlers, topic)
+	delete(t.tags, tag)
+}
+
+// Subscriptions returns the topics with handlers
+func (t *Topics) Subscriptions() (topics []string) {
+	t.RLock()
+	defer t.RUnlock()
+	for topic := range t.handlers {
+		topics = append(topics, topic)
+	}
+	return
+}
+
+// match returns the topic and handler for a tag if it is subscribed
+func (t *Topics) match(tag []byte) (topic string, handler HandlerFunc, ok bool) {
+	t.RLock()
+	defer t.RUnlock()
+	if topic, ok = t.tags[string(tag)]; ok {
+		handler = t.handlers[topic]
+	}
+	return
+}
+
+// Subscribe sets the handler for messages published on a topic on the channel
+func (c *Channel) Subscribe(topic string, handler HandlerFunc) {
+	c.topics.Subscribe(topic, handler)
+}
+
+// Unsubscribe stops handling a topic on the channel
+func (c *Channel) Unsubscribe(topic string) {
+	c.topics.Unsubscribe(topic)
+}
+
+// Topics returns the subscriptions of the channel
+func (c *Channel) Topics() *Topics {
+	return c.topics
+}
+
+// Publish sends a message on a topic
+func (c *Channel) Publish(topic string, data []byte) (err error) {
+	if len(topic) == 0 || len(topic) > MaxTopicLength {
+		return errors.New("topic must be between 1 and 255 bytes long")
+	}
+	if len(data) == 0 {
+		return errors.New("not publishing empty message")
+	}
+	body := make([]byte, 1+len(topic)+len(data))
+	body[0] = byte(len(topic))
+	copy(body[1:], topic)
+	copy(body[1+len(topic):], data)
+	var shards [][]byte
+	if shards, err = c.Codec().Encode(body); slog.Check(err) {
+		return
+	}
+	// the shards share a message ID sealed in with them, and each is sealed under its own nonce
+	var id []byte
+	if id, err = NewMessageID(); slog.Check(err) {
+		return
+	}
+	ciph := c.sendCiph()
+	tag := c.topics.Tag(topic)
+	for i := range shards {
+		var nonce []byte
+		if nonce, err = GetNonce(ciph); slog.Check(err) {
+			return
+		}
+		header := make([]byte, 0, len(TopicMagic)+TopicTagSize+len(nonce))
+		header = append(append(append(header, TopicMagic...), tag...), nonce...)
+		var packet []byte
+		if packet, err = gcm.Seal(ciph, header, nonce, joinPayload(id, c.Creator, shards[i]), tag); slog.Check(err) {
+			return
+		}
+		if _, err = c.Sender.Write(packet); slog.Check(err) {
+			return
+		}
+	}
+	return
+}
+
+// OpenTopicPacket splits a topic packet into its tag and nonce and deciphers the payload, returning the message ID,
+// sender ID and data that were sealed in it
+func OpenTopicPacket(ciph cipher.AEAD, packet []byte) (tag, id []byte, sender string, data []byte, err error) {
+	nL := ciph.NonceSize()
+	hL := len(TopicMagic) + TopicTagSize + nL
+	if len(packet) < hL || string(packet[:len(TopicMagic)]) != string(TopicMagic) {
+		err = errors.New("not a topic packet")
+		return
+	}
+	tag = packet[len(TopicMagic) : len(TopicMagic)+TopicTagSize]
+	nonce := packet[len(TopicMagic)+TopicTagSize : hL]
+	var plain []byte
+	if plain, err = ciph.Open(nil, nonce, packet[hL:], tag); err != nil {
+		return
+	}
+	id, sender, data, err = splitPayload(plain)
+	return
+}
+
+// handleTopic processes a topic packet, discarding it before deciphering if the topic is not subscribed
+func (c *Channel) handleTopic(address string, src net.Addr, packet []byte) {
+	if len(packet) < len(TopicMagic)+TopicTagSize {
+		return
+	}
+	topic, handler, ok := c.topics.match(packet[len(TopicMagic) : len(TopicMagic)+TopicTagSize])
+	if !ok {
+		c.topics.Filtered.Inc()
+		return
+	}
+	tag, id, sender, shard, err := OpenTopicPacket(c.receiveCiph(), packet)
+	if err != nil {
+		return
+	}
+	var body []byte
+	key := messageKey(src.String(), sender, string(tag)+string(id), c.multiPath.Load())
+	if body, err = c.buffers.Add(key, src, shard, c.Codec()); err != nil {
+		slog.Error(err)
+		return
+	}
+	if body == nil {
+		return
+	}
+	if len(body) < 1 || len(body) < 1+int(body[0]) || string(body[1:1+int(body[0])]) != topic {
+		slog.Debug("topic message does not match its tag")
+		return
+	}
+	slog.Debugf("received message on topic %s from %s", topic, src.String())
+	if err = handler(c.context, src, address, body[1+int(body[0]):]); slog.Check(err) {
+	}
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTopics(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	const port = 11873
+	receiver, err := NewBroadcastChannel("receiver", nil, "topic test", port, 8192, Handlers{}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 4)
+	receiver.Subscribe("weather", func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+		received <- "weather " + string(b)
+		return
+	})
+	publisher, err := NewBroadcastChannel("publisher", nil, "topic test", port, 8192, Handlers{}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = publisher.Publish("traffic", []byte("jammed")); err != nil {
+		t.Fatal(err)
+	}
+	if err = publisher.Publish("weather", []byte("sunny")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "weather sunny" {
+			t.Fatal("received", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("subscribed topic was not received")
+	}
+	select {
+	case msg := <-received:
+		t.Fatal("received unexpected message", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if f := receiver.Topics().Filtered.Load(); f != 9 {
+		t.Fatal("expected the 9 packets of the unsubscribed topic to be filtered, got", f)
+	}
+	receiver.Unsubscribe("weather")
+	if len(receiver.Topics().Subscriptions()) != 0 {
+		t.Fatal("topic was not unsubscribed")
+	}
+}
+
+func TestTopicPackets(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	publisher, err := NewUnicastChannel("publisher", nil, "topic test", conn.LocalAddr().String(), "127.0.0.1:0",
+		8192, Handlers{}, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = publisher.Publish("weather", []byte("sunny")); err != nil {
+		t.Fatal(err)
+	}
+	// the shards of a message share its sealed ID but each has its own nonce
+	nL := publisher.receiveCiph().NonceSize()
+	hL := len(TopicMagic) + TopicTagSize
+	nonces := make(map[string]bool)
+	var first []byte
+	var captured []*CapturedPacket
+	buffer := make([]byte, 8192)
+	for i := 0; i < 9; i++ {
+		if err = conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := append([]byte{}, buffer[:n]...)
+		_, id, sender, _, err := OpenTopicPacket(publisher.receiveCiph(), packet)
+		if err != nil || sender != "publisher" {
+			t.Fatal("topic packet did not open", sender, err)
+		}
+		if first == nil {
+			first = id
+		} else if string(id) != string(first) {
+			t.Fatal("shards of a topic message have different message IDs")
+		}
+		nonce := string(packet[hL : hL+nL])
+		if nonces[nonce] {
+			t.Fatal("two shards of a topic message were sealed under the same nonce")
+		}
+		nonces[nonce] = true
+		captured = append(captured, &CapturedPacket{Data: packet})
+	}
+	// offline decoding finds the nonce after the tag
+	for i, info := range DecodeCapture(publisher.receiveCiph(), nil, captured) {
+		if !info.Opened || !bytes.Equal(info.Nonce, captured[i].Data[hL:hL+nL]) {
+			t.Fatal("captured topic packet", i, "has the wrong nonce:", info)
+		}
+	}
+}
@@ -3,15 +3,12 @@ package transport
 import (
 	"context"
 	"crypto/cipher"
-	"crypto/rand"
 	"errors"
-	"io"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/p9c/pkg/app/slog"
-	"github.com/p9c/pkg/coding/fek"
 )
 
 type HandleFunc map[string]func(ctx interface{}) func(b []byte) (err error)
@@ -22,7 +19,7 @@ type HandleFunc map[string]func(ctx interface{}) func(b []byte) (err error)
 // local network control systems
 type Connection struct {
 	maxDatagramSize int
-	buffers         map[string]*MsgBuffer
+	buffers         *reassembler
 	sendAddress     *net.UDPAddr
 	SendConn        net.Conn
 	listenAddress   *net.UDPAddr
@@ -94,24 +91,20 @@ func (c *Connection) SetSendConn(ad string) (err error) {
 func (c *Connection) CreateShards(b, magic []byte) (shards [][]byte,
 	err error) {
 	magicLen := 4
-	// get a nonce for the packet, it is both message ID and salt
-	nonceLen := c.ciph.NonceSize()
-	nonce := make([]byte, nonceLen)
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	// the shards share a message ID and are each sealed under their own nonce
+	var id []byte
+	if id, err = NewMessageID(); err != nil {
 		slog.Error(err)
 		return
 	}
 	// generate the shards
-	shards, err = fek.Encode(b)
+	shards, err = DefaultCodec.Encode(b)
 	for i := range shards {
-		encryptedShard := c.ciph.Seal(nil, nonce, shards[i], nil)
-		shardLen := len(encryptedShard)
 		// assemble the packet: magic, nonce, and encrypted shard
-		outBytes := make([]byte, shardLen+magicLen+nonceLen)
-		copy(outBytes, magic[:magicLen])
-		copy(outBytes[magicLen:], nonce)
-		copy(outBytes[magicLen+nonceLen:], encryptedShard)
-		shards[i] = outBytes
+		if shards[i], err = EncryptMessage("", c.ciph, magic[:magicLen], id, shards[i]); err != nil {
+			slog.Error(err)
+			return
+		}
 	}
 	return
 }
@@ -185,6 +178,9 @@ func (c *Connection) Listen(handlers HandleFunc, ifc interface{},
 	lastSent *time.Time, firstSender *string) (err error) {
 	slog.Trace("setting read buffer")
 	buffer := make([]byte, c.maxDatagramSize)
+	if c.buffers == nil {
+		c.buffers = newReassembler()
+	}
 	go func() {
 		slog.Trace("starting connection handler")
 	out:
@@ -203,54 +199,25 @@ func (c *Connection) Listen(handlers HandleFunc, ifc interface{},
 				if lastSent != nil && firstSender != nil {
 					*lastSent = time.Now()
 				}
-				nonceBytes := buf[4:16]
-				nonce := string(nonceBytes)
 				// decipher
-				shard, err := c.ciph.Open(nil, nonceBytes,
-					buf[16:], nil)
+				_, id, sender, shard, err := OpenPacket(c.ciph, buf)
 				if err != nil {
 					// Error(err)
 					// corrupted or irrelevant message
 					continue
 				}
-				if bn, ok := c.buffers[nonce]; ok {
-					if !bn.Decoded {
-						bn.Buffers = append(bn.Buffers, shard)
-						if len(bn.Buffers) >= 3 {
-							// try to decode it
-							var cipherText []byte
-							cipherText, err = fek.Decode(bn.Buffers)
-							if err != nil {
-								slog.Error(err)
-								continue
-							}
-							bn.Decoded = true
-							err = handlers[magic](ifc)(cipherText)
-							if err != nil {
-								slog.Error(err)
-								continue
-							}
-						}
-					} else {
-						for i := range c.buffers {
-							if i != nonce {
-								// superseded messages can be deleted from the
-								// buffers,
-								// we don't add more data for the already
-								// decoded.
-								// Trace("deleting superseded buffer",
-								// 	hex.EncodeToString([]byte(i)))
-								delete(c.buffers, i)
-							}
-						}
+				var cipherText []byte
+				if cipherText, err = c.buffers.Add(messageKey(src.String(), sender, string(id), false), src,
+					shard, DefaultCodec); err != nil {
+					slog.Error(err)
+					continue
+				}
+				if cipherText != nil {
+					err = handlers[magic](ifc)(cipherText)
+					if err != nil {
+						slog.Error(err)
+						continue
 					}
-				} else {
-					// Trace("new message arriving",
-					// 	hex.EncodeToString([]byte(nonce)))
-					c.buffers[nonce] = &MsgBuffer{[][]byte{},
-						time.Now(), false, src}
-					c.buffers[nonce].Buffers = append(c.buffers[nonce].
-						Buffers, shard)
 				}
 			}
 			select {